@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultAccessRequestInterval - default minimal interval between access requests from one user
+const DefaultAccessRequestInterval time.Duration = time.Hour
+
+// access request from unknown user
+type accessRequest struct {
+	Name     string             // name from telegram profile
+	Lang     string             // language code from telegram profile
+	Time     time.Time          // last request time
+	Messages []tgbotapi.Message // request messages with buttons sent to admins
+}
+
+// pending and recently denied access requests, key is uid
+var (
+	AccessRequests   = make(map[int64]*accessRequest)
+	AccessRequestsMu sync.Mutex
+)
+
+// get full name from telegram profile
+func profileName(u *tgbotapi.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		name = u.UserName
+	}
+	return name
+}
+
+// get minimal interval between access requests
+func accessRequestInterval() time.Duration {
+	if i := getConfig().AccessRequestInterval; i > 0 {
+		return i
+	}
+	return DefaultAccessRequestInterval
+}
+
+// send access request with approve/deny buttons to admins
+func newUserHandler(u *tgbotapi.User) {
+	interval := accessRequestInterval()
+	AccessRequestsMu.Lock()
+	if r, ok := AccessRequests[u.ID]; ok && time.Since(r.Time) < interval {
+		AccessRequestsMu.Unlock()
+		logWarning("[user] Repeated access request skipped", "uid", u.ID)
+		sendTo(u.ID, translate(pickLang(u.LanguageCode), "Your request is already sent. Try again later."))
+		return
+	}
+	// forget outdated requests
+	for id, r := range AccessRequests {
+		if time.Since(r.Time) >= interval {
+			delete(AccessRequests, id)
+		}
+	}
+	r := &accessRequest{Name: profileName(u), Lang: u.LanguageCode, Time: time.Now()}
+	AccessRequests[u.ID] = r
+	AccessRequestsMu.Unlock()
+
+	logInfo("[user] Authorization requested", "uid", u.ID, "user", r.Name)
+	cfg := getConfig()
+	var msgs []tgbotapi.Message
+	for _, id := range append(adminIDs(), cfg.AdminGroup) {
+		if id == 0 {
+			continue
+		}
+		lang := userLang(id)
+		msg := fmt.Sprintf(translate(lang, "User <a href=\"tg://user?id=%d\">%s</a>"+
+			" requests authorization:\nid: <code>%d</code>"), u.ID, html.EscapeString(r.Name), u.ID)
+		kb := genKeyboard([][]map[string]string{
+			{
+				{translate(lang, "approve as viewer"): fmt.Sprintf("user approve %d %s", u.ID, RoleViewer)},
+				{translate(lang, "approve as engineer"): fmt.Sprintf("user approve %d %s", u.ID, RoleEngineer)},
+			},
+			{{translate(lang, "deny"): fmt.Sprintf("user deny %d", u.ID)}},
+		})
+		if m, err := sendMessage(id, msg, kb); err == nil {
+			msgs = append(msgs, m)
+		}
+	}
+	AccessRequestsMu.Lock()
+	r.Messages = msgs
+	AccessRequestsMu.Unlock()
+	sendTo(u.ID, translate(pickLang(u.LanguageCode), "Your request is accepted. Waiting confirmation from admin."))
+}
+
+// get access request and detach its messages, denied requests are kept for rate limiting
+func takeAccessRequest(uid int64, denied bool) *accessRequest {
+	AccessRequestsMu.Lock()
+	defer AccessRequestsMu.Unlock()
+	r, ok := AccessRequests[uid]
+	if !ok {
+		return nil
+	}
+	res := *r
+	if denied {
+		r.Time = time.Now()
+		r.Messages = nil
+	} else {
+		delete(AccessRequests, uid)
+	}
+	return &res
+}
+
+// replace buttons in access request messages with decision, except message already edited
+func closeAccessRequest(r *accessRequest, except *tgbotapi.Message, text string) {
+	if r == nil {
+		return
+	}
+	for i := range r.Messages {
+		m := &r.Messages[i]
+		if except != nil && m.Chat.ID == except.Chat.ID && m.MessageID == except.MessageID {
+			continue
+		}
+		editTextRemoveKeyboard(m, text)
+	}
+}
+
+// access request buttons handler, permission is checked in callback route,
+// empty result keeps request message unchanged
+func accessHandler(ctx context.Context, data *UserData, msg *tgbotapi.Message, args string) string {
+	action, other := splitArgs(args)
+	u, roleName := splitArgs(other)
+	uid, err := strconv.ParseInt(u, 10, 64)
+	if err != nil || uid == 0 {
+		return fmtErr(tr(ctx, "Wrong uid"))
+	}
+	if userIsAuthorized(uid) {
+		return trf(ctx, "User <code>%d</code> <b>%s</b> is already authorized.", uid, userName(uid))
+	}
+	admin := ctxUID(ctx)
+	switch action {
+	case "approve":
+		role, err := parseRole(roleName)
+		if err != nil || roleName == "" {
+			return fmtErr(tr(ctx, "Wrong role"))
+		}
+		// request is taken by first admin, other admins pressing approve at the same time get nothing
+		r := takeAccessRequest(uid, false)
+		if r == nil {
+			return trf(ctx, "Access request of user <code>%d</code> is already handled or expired.", uid)
+		}
+		name, lang := r.Name, r.Lang
+		if err = initUserConfig(uid, name, role.String()); err != nil {
+			return fmtErr(err.Error())
+		}
+		initUserData(uid)
+		setUserLang(uid, lang)
+		logInfo("[user] Approved", append(userFields(uid), "role", role, "admin", userName(admin))...)
+		sendTo(uid, translate(userLang(uid), "You are added to authorized users list."))
+		res := trf(ctx, "User <code>%d</code> <b>%s</b> approved as <code>%s</code> by <i>%s</i>.",
+			uid, html.EscapeString(userName(uid)), role, html.EscapeString(userName(admin)))
+		closeAccessRequest(r, msg, res)
+		return res
+	case "deny":
+		// denial reason is the next message in comment mode
+		kb := tgbotapi.NewReplyKeyboard(tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("cancel")))
+		text := trf(ctx, "Send denial reason for user <code>%d</code>, it will be forwarded to user.", uid)
+		if _, err := sendMessage(admin, text, kb); err != nil {
+			// no private chat with admin, deny without reason
+			return denyUser(ctx, uid, "", msg)
+		}
+		data.Mode = "comment"
+		data.TMP = fmt.Sprintf("deny %d", uid)
+		return ""
+	}
+	return fmtErr(tr(ctx, "Wrong action"))
+}
+
+// deny access request and send reason to user
+func denyUser(ctx context.Context, uid int64, reason string, msg *tgbotapi.Message) string {
+	if userIsAuthorized(uid) {
+		return trf(ctx, "User <code>%d</code> <b>%s</b> is already authorized.", uid, userName(uid))
+	}
+	admin := ctxUID(ctx)
+	r := takeAccessRequest(uid, true)
+	// denied user has no data, language is taken from request
+	lang := userLang(uid)
+	if r != nil {
+		lang = pickLang(r.Lang)
+	}
+	text := translate(lang, "Your request is denied.")
+	res := trf(ctx, "User <code>%d</code> denied by <i>%s</i>.", uid, html.EscapeString(userName(admin)))
+	if reason != "" {
+		text += "\n" + translate(lang, "Reason:") + " " + html.EscapeString(reason)
+		res += "\n" + tr(ctx, "Reason:") + " " + html.EscapeString(reason)
+	}
+	logInfo("[user] Denied", "uid", uid, "admin", userName(admin), "reason", reason)
+	sendTo(uid, text)
+	closeAccessRequest(r, msg, res)
+	return res
+}
+
+// comment mode handler, TMP keeps action waiting for comment
+func commentHandler(ctx context.Context, data *UserData, msg string) string {
+	action, arg := splitArgs(data.TMP)
+	data.Mode, data.TMP = "", ""
+	clearReplyKeyboard(ctxUID(ctx))
+	if msg == "cancel" {
+		return tr(ctx, "Cancelled")
+	}
+	switch action {
+	case "deny":
+		uid, _ := strconv.ParseInt(arg, 10, 64)
+		if !can(ctx, "user deny") {
+			return tr(ctx, MsgNoPermission)
+		}
+		return denyUser(ctx, uid, msg, nil)
+	}
+	return fmtErr(tr(ctx, "Nothing to comment"))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAuditMaxSize - default audit file size in megabytes before rotation
+const DefaultAuditMaxSize int = 10
+
+// DefaultAuditBackups - default number of rotated audit files to keep
+const DefaultAuditBackups int = 5
+
+// audit query result limit
+const auditQueryLimit int = 20
+
+// AuditEntry - single user action in audit log
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	UID    int64     `json:"uid"`
+	User   string    `json:"user"`
+	Kind   string    `json:"kind"`           // message, callback or action
+	Mode   string    `json:"mode,omitempty"` // user mode or callback mode
+	Action string    `json:"action,omitempty"`
+	Args   string    `json:"args,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Port   string    `json:"port,omitempty"`
+	Result string    `json:"result"` // ok, error or denied
+	Error  string    `json:"error,omitempty"`
+}
+
+// AuditFilter - audit log query
+type AuditFilter struct {
+	UID   int64
+	IP    string
+	Since time.Time
+}
+
+// match entry with filter, empty fields match any entry
+func (f AuditFilter) match(e AuditEntry) bool {
+	return (f.UID == 0 || e.UID == f.UID) &&
+		(f.IP == "" || e.IP == f.IP) &&
+		!e.Time.Before(f.Since)
+}
+
+// AuditLog - append-only json lines file with size based rotation
+type AuditLog struct {
+	filename string
+	maxSize  int64 // bytes
+	backups  int
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+// Audit - audit log object, nil if audit is disabled
+var Audit *AuditLog
+
+// open audit log file for appending
+func openAuditLog(filename string, maxSize int, backups int) (*AuditLog, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultAuditMaxSize
+	}
+	if backups <= 0 {
+		backups = DefaultAuditBackups
+	}
+	a := &AuditLog{filename: filename, maxSize: int64(maxSize) << 20, backups: backups}
+	return a, a.open()
+}
+
+// open current file and get its size
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, st.Size()
+	return nil
+}
+
+// rotated file name, 0 is current file
+func (a *AuditLog) backupName(n int) string {
+	if n == 0 {
+		return a.filename
+	}
+	return fmt.Sprintf("%s.%d", a.filename, n)
+}
+
+// shift rotated files and start new current file
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	for n := a.backups - 1; n >= 0; n-- {
+		err := os.Rename(a.backupName(n), a.backupName(n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return a.open()
+}
+
+// Write - append entry to audit file
+func (a *AuditLog) Write(e AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.size > 0 && a.size+int64(len(data)) > a.maxSize {
+		if err = a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	return err
+}
+
+// Query - get last matching entries from all audit files, oldest first
+func (a *AuditLog) Query(f AuditFilter, limit int) ([]AuditEntry, error) {
+	// open files under lock, opened files are read after rotation, writes are not blocked by reading
+	var files []*os.File
+	a.mu.Lock()
+	for n := a.backups; n >= 0; n-- {
+		file, err := os.Open(a.backupName(n))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			a.mu.Unlock()
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	a.mu.Unlock()
+	defer closeFiles(files)
+	var res []AuditEntry
+	for _, file := range files {
+		s := bufio.NewScanner(file)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			var e AuditEntry
+			// last line of current file may be partially written
+			if json.Unmarshal(s.Bytes(), &e) != nil || !f.match(e) {
+				continue
+			}
+			res = append(res, e)
+			if len(res) > limit {
+				res = res[1:]
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// close all files
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Close - close current file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// write user action from context to audit log
+func audit(ctx context.Context, e AuditEntry) {
+	if Audit == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.UID = ctxUID(ctx)
+	e.User = userName(e.UID)
+	if e.IP == "" {
+		e.IP, e.Port = auditTarget(e.Args)
+	}
+	if err := Audit.Write(e); err != nil {
+		logError("[audit] Write failed", "error", err)
+	}
+}
+
+// get switch ip and port from command args
+func auditTarget(args string) (ip string, port string) {
+	a, other := splitArgs(args)
+	if ip = fullIP(a, false); ip == "" {
+		return "", ""
+	}
+	p, _ := splitArgs(other)
+	if _, err := strconv.Atoi(p); err == nil {
+		port = p
+	}
+	return ip, port
+}
+
+// get audit result from handler output
+func auditResult(res string) string {
+	switch {
+	case containsMsg(res, MsgNoPermission):
+		return "denied"
+	case strings.Contains(res, "<b>ERROR</b>"):
+		return "error"
+	}
+	return "ok"
+}
+
+// parse duration with days support, e.g. 2d or 12h
+func parseSince(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+// audit query command handler: [IP] [user ID] [since DURATION]
+func auditHandler(ctx context.Context, args string) string {
+	if Audit == nil {
+		return fmtErr(tr(ctx, "Audit log is disabled"))
+	}
+	var f AuditFilter
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "user", "since":
+			if i+1 >= len(fields) {
+				return fmtErr(trf(ctx, "Missing value for %s", fields[i]))
+			}
+			if fields[i] == "user" {
+				uid, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return fmtErr(tr(ctx, "Wrong uid"))
+				}
+				f.UID = uid
+			} else {
+				d, err := parseSince(fields[i+1])
+				if err != nil {
+					return fmtErr(tr(ctx, "Wrong duration"))
+				}
+				f.Since = time.Now().Add(-d)
+			}
+			i++
+		default:
+			if f.IP = fullIP(fields[i], false); f.IP == "" {
+				return fmtErr(tr(ctx, "Wrong ip"))
+			}
+		}
+	}
+	entries, err := Audit.Query(f, auditQueryLimit)
+	if err != nil {
+		return fmtErr(err.Error())
+	}
+	if len(entries) == 0 {
+		return tr(ctx, "No entries found")
+	}
+	var res string
+	for _, e := range entries {
+		action := strings.TrimSpace(strings.Join([]string{e.Mode, e.Action, e.Args}, " "))
+		if r := []rune(action); len(r) > 60 {
+			action = string(r[:60]) + "..."
+		}
+		res += fmt.Sprintf("<code>%s</code> <b>%s</b> %s <i>%s</i>\n",
+			localTime(ctx, e.Time).Format("02.01 15:04"), html.EscapeString(e.User), html.EscapeString(action), e.Result)
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxCallbackData - telegram limit for callback data in bytes
+const MaxCallbackData int = 64
+
+// CallbackVersion - version of callback tokens, increase it when callback format changes
+// to expire buttons in chat history
+const CallbackVersion int = 1
+
+// time to keep long callback payloads, telegram does not allow to delete older messages
+const callbackTTL time.Duration = 48 * time.Hour
+
+// payload time refreshed by showing button again is saved to store not more often than this
+const callbackSaveInterval time.Duration = time.Hour
+
+// prefix of callback token, it is never used in plain callback data
+const callbackTokenPrefix string = "#"
+
+// MsgButtonExpired - answer for expired button
+const MsgButtonExpired string = "Button expired, please repeat request"
+
+// ErrCallbackExpired - callback token is unknown, outdated or from previous version
+var ErrCallbackExpired = errors.New("callback expired")
+
+// long callback payload stored on server, it is saved to user data store to survive restarts
+type callbackPayload struct {
+	Data string
+	Time time.Time
+}
+
+// long callback payloads by token and tokens by payload
+var (
+	callbackTokens   = make(map[string]*callbackPayload)
+	callbackPayloads = make(map[string]string)
+	callbackMu       sync.Mutex
+)
+
+// generate random token for callback payload
+func newCallbackToken() string {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		// fallback is unique enough for one process
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// encode callback data, long payloads are stored on server and replaced with token,
+// same payload gets the same token while it is not expired
+func encodeCallback(data string) string {
+	if len(data) <= MaxCallbackData && !strings.HasPrefix(data, callbackTokenPrefix) {
+		return data
+	}
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	changed := false
+	// forget outdated payloads
+	for token, p := range callbackTokens {
+		if time.Since(p.Time) > callbackTTL {
+			delete(callbackTokens, token)
+			delete(callbackPayloads, p.Data)
+			changed = true
+		}
+	}
+	token, ok := callbackPayloads[data]
+	if !ok {
+		token = newCallbackToken()
+		callbackTokens[token] = &callbackPayload{Data: data}
+		callbackPayloads[data] = token
+		logDebug("[callback] Payload stored", "size", len(data), "token", token)
+	}
+	// button is shown again, keep payload longer
+	p := callbackTokens[token]
+	if time.Since(p.Time) > callbackSaveInterval {
+		changed = true
+	}
+	p.Time = time.Now()
+	if changed && Storage != nil {
+		if err := Storage.SaveCallbacks(callbackTokens); err != nil {
+			logError("[callback] Save tokens failed", "error", err)
+		}
+	}
+	return fmt.Sprintf("%s%d:%s", callbackTokenPrefix, CallbackVersion, token)
+}
+
+// decode callback data, payloads of expired tokens are not available
+func decodeCallback(data string) (string, error) {
+	if !strings.HasPrefix(data, callbackTokenPrefix) {
+		return data, nil
+	}
+	version, token := splitArgs(strings.Replace(strings.TrimPrefix(data, callbackTokenPrefix), ":", " ", 1))
+	if v, err := strconv.Atoi(version); err != nil || v != CallbackVersion {
+		return "", ErrCallbackExpired
+	}
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	p, ok := callbackTokens[token]
+	if !ok || time.Since(p.Time) > callbackTTL {
+		return "", ErrCallbackExpired
+	}
+	return p.Data, nil
+}
+
+// load callback tokens saved in store before restart
+func loadCallbacks() {
+	tokens, err := Storage.LoadCallbacks()
+	if err != nil {
+		logError("[callback] Load tokens failed", "error", err)
+		return
+	}
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	callbackTokens = tokens
+	callbackPayloads = make(map[string]string, len(tokens))
+	for token, p := range tokens {
+		callbackPayloads[p.Data] = token
+	}
+	logInfo("[callback] Tokens loaded", "count", len(tokens))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix - prefix for config environment variables, e.g. INKOTOOLS_BOT_TOKEN for bot_token
+const EnvPrefix string = "INKOTOOLS_"
+
+// ConfigError - list of config problems
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid config:\n - " + strings.Join(e, "\n - ")
+}
+
+// environment variable name for config field
+func envName(field reflect.StructField) string {
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	return EnvPrefix + strings.ToUpper(tag)
+}
+
+// override config fields from environment variables, NAME_FILE variants read value from file
+// (docker secrets), strings are used as is, other values are parsed as yaml, e.g. [1, 2] or {switch: 5m}
+func applyEnv(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := envName(t.Field(i))
+		val, ok := os.LookupEnv(name)
+		if filename, fileOK := os.LookupEnv(name + "_FILE"); fileOK {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %v", name, err)
+			}
+			val, ok = strings.TrimSpace(string(data)), true
+		}
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.String {
+			f.SetString(val)
+		} else if err := yaml.Unmarshal([]byte(val), f.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		logDebug("[config] Option is set from environment", "env", name)
+	}
+	return nil
+}
+
+// bot token format: bot id and secret
+var reBotToken = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]+$`)
+
+// check tcp port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// check absolute http(s) url
+func validURL(s string, schemes ...string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// validate config, all problems are returned at once
+func (c Config) validate() error {
+	var e ConfigError
+	if c.BotToken == "" {
+		e = append(e, "bot_token is empty, get it from @BotFather or set INKOTOOLS_BOT_TOKEN(_FILE)")
+	} else if !reBotToken.MatchString(c.BotToken) {
+		e = append(e, "bot_token is malformed, expected format is 1234567890:ABcdef...")
+	}
+	if c.InkoToolsAPI == "" {
+		e = append(e, "inkotools_api_url is empty")
+	} else if !validURL(c.InkoToolsAPI, "http", "https") {
+		e = append(e, fmt.Sprintf("inkotools_api_url %q is not valid http(s) url, e.g. http://127.0.0.1:9999/", c.InkoToolsAPI))
+	}
+	if c.UseWebhook {
+		if !validURL(c.WebhookURL, "https") {
+			e = append(e, fmt.Sprintf("webhook_url %q is not valid https url, telegram requires https for webhooks", c.WebhookURL))
+		} else if !strings.HasSuffix(c.WebhookURL, "/") {
+			e = append(e, fmt.Sprintf("webhook_url %q must end with /, bot token is appended to it", c.WebhookURL))
+		}
+		if c.ListenPort == "" {
+			e = append(e, "listen_port is required for webhook")
+		}
+	}
+	if c.ListenPort != "" && !validPort(c.ListenPort) {
+		e = append(e, fmt.Sprintf("listen_port %q is not valid port number", c.ListenPort))
+	}
+	if c.HealthPort != "" && !validPort(c.HealthPort) {
+		e = append(e, fmt.Sprintf("health_port %q is not valid port number", c.HealthPort))
+	}
+	if len(c.adminIDs()) == 0 {
+		e = append(e, "no admins, set admin or admins to your telegram user id")
+	}
+	switch c.Store {
+	case "", "gob", "bolt":
+	default:
+		e = append(e, fmt.Sprintf("store %q is unknown, use gob or bolt", c.Store))
+	}
+	switch c.LogFormat {
+	case "", "logfmt", "json":
+	default:
+		e = append(e, fmt.Sprintf("log_format %q is unknown, use logfmt or json", c.LogFormat))
+	}
+	if c.LogLevel != "" {
+		if _, err := parseLevel(c.LogLevel); err != nil {
+			e = append(e, fmt.Sprintf("log_level: %v", err))
+		}
+	}
+	for sub, name := range c.LogLevels {
+		if _, err := parseLevel(name); err != nil {
+			e = append(e, fmt.Sprintf("log_levels.%s: %v", sub, err))
+		}
+	}
+	if c.Timezone != "" {
+		if _, err := loadLocation(c.Timezone); err != nil {
+			e = append(e, fmt.Sprintf("timezone %q is unknown, use tz database name, e.g. Europe/Moscow", c.Timezone))
+		}
+	}
+	if c.Language != "" && parseLang(c.Language) == "" {
+		e = append(e, fmt.Sprintf("language %q is not supported, use one of: %s", c.Language, strings.Join(languages(), ", ")))
+	}
+	e = append(e, c.validateNetwork()...)
+	// negative numbers and durations make no sense
+	nonNegative := []struct {
+		name string
+		val  int64
+	}{
+		{"access_request_interval", int64(c.AccessRequestInterval)},
+		{"health_stuck_after", int64(c.HealthStuckAfter)},
+		{"shutdown_timeout", int64(c.ShutdownTimeout)},
+		{"api_timeout", int64(c.APITimeout)},
+		{"api_slow_timeout", int64(c.APISlowTimeout)},
+		{"api_retries", int64(c.APIRetries)},
+		{"api_down_after", int64(c.APIDownAfter)},
+		{"api_down_cooldown", int64(c.APIDownCooldown)},
+		{"summary_workers", int64(c.SummaryWorkers)},
+		{"summary_timeout", int64(c.SummaryTimeout)},
+		{"audit_max_size", int64(c.AuditMaxSize)},
+		{"audit_backups", int64(c.AuditBackups)},
+	}
+	for _, f := range nonNegative {
+		if f.val < 0 {
+			e = append(e, fmt.Sprintf("%s must not be negative", f.name))
+		}
+	}
+	for class, ttl := range c.APICache {
+		if ttl < 0 {
+			e = append(e, fmt.Sprintf("api_cache.%s must not be negative", class))
+		}
+	}
+	if len(e) > 0 {
+		return e
+	}
+	return nil
+}
+
+// load main config from file and environment and validate it, missing file is fine if
+// everything is set from environment
+func loadConfig(filename string) (Config, error) {
+	var cfg Config
+	_, err := os.Stat(filename)
+	switch {
+	case err == nil:
+		if err = readYML(&cfg, filename); err != nil {
+			return cfg, err
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		logWarning("[config] Config file not found, using environment only", "file", filename)
+	default:
+		// unreadable file is an error, not a reason to ignore it
+		return cfg, err
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateHandler - function to process single telegram update
+type UpdateHandler func(ctx context.Context, u tgbotapi.Update)
+
+// Dispatcher - processes updates concurrently across users, keeping per-user order
+type Dispatcher struct {
+	ctx     context.Context
+	handler UpdateHandler
+	mu      sync.Mutex
+	queues  map[int64][]tgbotapi.Update // pending updates, key is uid
+	running map[int64]time.Time         // start time of update in progress, key is uid
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher - create dispatcher for handler
+func NewDispatcher(ctx context.Context, handler UpdateHandler) *Dispatcher {
+	return &Dispatcher{
+		ctx:     ctx,
+		handler: handler,
+		queues:  make(map[int64][]tgbotapi.Update),
+		running: make(map[int64]time.Time),
+	}
+}
+
+// Dispatch - add update to user queue, start user worker if it is not running
+func (d *Dispatcher) Dispatch(u tgbotapi.Update) {
+	// updates are queued by sender, so group chat callbacks keep order with private ones,
+	// updates without sender and chat are queued together with uid 0
+	var uid int64
+	if s := u.SentFrom(); s != nil {
+		uid = s.ID
+	} else if c := u.FromChat(); c != nil {
+		uid = c.ID
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// queue key exists while user worker is running
+	q, running := d.queues[uid]
+	d.queues[uid] = append(q, u)
+	if !running {
+		d.wg.Add(1)
+		go d.worker(uid)
+	}
+}
+
+// Wait - wait for all queued updates to be processed
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Longest - processing time of the longest running update, zero if idle
+func (d *Dispatcher) Longest() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var res time.Duration
+	for _, start := range d.running {
+		if t := time.Since(start); t > res {
+			res = t
+		}
+	}
+	return res
+}
+
+// process user queue until it is empty
+func (d *Dispatcher) worker(uid int64) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		q := d.queues[uid]
+		if len(q) == 0 {
+			delete(d.queues, uid)
+			d.mu.Unlock()
+			return
+		}
+		u := q[0]
+		d.queues[uid] = q[1:]
+		d.running[uid] = time.Now()
+		d.mu.Unlock()
+		d.process(u)
+		d.mu.Lock()
+		delete(d.running, uid)
+		d.mu.Unlock()
+	}
+}
+
+// run handler, panic in one update should not affect others
+func (d *Dispatcher) process(u tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			logError("[dispatcher] Update panic", "update", u.UpdateID, "error", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	d.handler(d.ctx, u)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// raw requests produce views with expected text and buttons
+func TestRawHandler(t *testing.T) {
+	setupTest(t)
+	cases := []struct {
+		uid     int64
+		raw     string
+		text    []string
+		buttons []string
+	}{
+		{testViewer, "57.10", []string{"DES-3200-28", "Lenina 1, entrance 2", "available"},
+			[]string{"free ports", "access ports", "switch log", "export", "refresh", "close"}},
+		{testViewer, "192.168.57.20", []string{"DES-3200-10", "unavailable"},
+			[]string{"switch log", "export", "refresh", "close"}},
+		{testViewer, "57.10 free", []string{"Free ports:", "<b>7 </b>", "<b>12 </b>"},
+			[]string{testSwitch, "export", "close"}},
+		{testViewer, "57.10 access", []string{"kv 1", "kv &lt;12&gt;"},
+			[]string{"1", "2", "3", testSwitch, "export", "close"}},
+		{testViewer, "57.10 log", []string{"events [1 - 5]:", "Port 5 link down"},
+			[]string{"[1-5]", testSwitch, "export", "close"}},
+		{testViewer, "57.10 5", []string{"[<code>57.10</code>]", "kv 14", "MAC Table", "Updated:"},
+			[]string{"full", "port log", "export", "refresh", "repeat", "close"}},
+		{testViewer, "57.10 5 full", []string{"VLAN untagged", "ACL", "ARP Table", "ONLINE"},
+			[]string{"short", "port log", "export", "refresh", "repeat", "close"}},
+		{testEngineer, "57.10 5", []string{"kv 14"},
+			[]string{"full", "port log", "clear counters", "close"}},
+		{testViewer, "57.10 5 log", []string{"events [1 - 5]:", "Port 5 link up"},
+			[]string{"[1-5]", testSwitch + " 5", "export", "close"}},
+		{testViewer, "10.0.0.1", []string{"10.0.0.1 is a client ip, not a switch ip"},
+			[]string{"close"}},
+		{testViewer, "lenina", []string{"Entries found: <b>6</b>", "192.168.57.20"},
+			[]string{">", "close"}},
+		{testViewer, "57.30", []string{"ERROR", "Not found: /sw/192.168.57.30/"},
+			[]string{"close"}},
+		// port summary fails without access ports
+		{testViewer, "57.11 1", []string{"ERROR", "Not found: /sw/192.168.57.11/ports/"},
+			[]string{"close"}},
+	}
+	for _, c := range cases {
+		t.Run(c.raw, func(t *testing.T) {
+			text, kb := rawHandler(userCtx(c.uid), c.raw)
+			assertContains(t, text, c.text...)
+			b := buttons(kb)
+			for _, name := range c.buttons {
+				if _, ok := b[name]; !ok {
+					t.Errorf("button %q not found in %v", name, b)
+				}
+			}
+		})
+	}
+}
+
+// viewer can not clear counters, engineer can
+func TestSwHandlerClear(t *testing.T) {
+	_, api := setupTest(t)
+	text, kb := swHandler(userCtx(testViewer), testSwitch, "5 short clear")
+	assertContains(t, text, MsgNoPermission)
+	if _, ok := buttons(kb)["clear counters"]; ok {
+		t.Error("viewer got clear counters button")
+	}
+	if api.requested("DELETE") {
+		t.Error("counters cleared by viewer")
+	}
+	swHandler(userCtx(testEngineer), testSwitch, "5 short clear")
+	if !api.requested("DELETE /sw/192.168.57.10/ports/5/counters") {
+		t.Error("counters are not cleared by engineer")
+	}
+}
+
+// message is answered by editing placeholder, user input is deleted
+func TestMessageUpdate(t *testing.T) {
+	tg, api := setupTest(t)
+	handleUpdate(context.Background(), messageUpdate(testViewer, "57.10"))
+	sent := tg.flush()
+	if m, ok := sent[0].(tgbotapi.MessageConfig); !ok || m.Text != "Waiting..." {
+		t.Fatalf("first request is not placeholder: %#v", sent[0])
+	}
+	text, kb := lastView(sent)
+	assertContains(t, text, "DES-3200-28")
+	if b := buttons(kb); b["free ports"] != "raw edit 192.168.57.10 free" {
+		t.Errorf("wrong free ports button: %v", b)
+	}
+	if d, ok := sent[len(sent)-1].(tgbotapi.DeleteMessageConfig); !ok || d.MessageID != 1000 {
+		t.Errorf("user input is not deleted: %#v", sent[len(sent)-1])
+	}
+	if !api.requested("GET /sw/192.168.57.10/") {
+		t.Error("switch is not requested")
+	}
+	// request is saved in history
+	d := getUserData(testViewer)
+	if len(d.History) != 1 || d.History[0] != "57.10" || d.LastSwitch != testSwitch {
+		t.Errorf("wrong user data: %+v", d)
+	}
+	handleUpdate(context.Background(), messageUpdate(testViewer, "/history"))
+	text, _ = lastView(tg.flush())
+	assertContains(t, text, "<code>57.10</code>", "Last switch: <code>192.168.57.10</code>")
+}
+
+// commands are routed with permissions and argument schema
+func TestCommands(t *testing.T) {
+	tg, _ := setupTest(t)
+	cases := []struct {
+		uid  int64
+		text string
+		want string
+	}{
+		{testViewer, "/help", "<code>/ping IP</code> - ping"},
+		{testViewer, "/calc", "Usage: <code>/calc IP</code>"},
+		{testViewer, "/calc 10.15.1.14", "255.255.255.0"},
+		{testViewer, "/admin list", MsgNoPermission},
+		{testViewer, "/admin broadcast hi", MsgNoPermission},
+		{testNewbie, "/admin", MsgNoPermission},
+		{testNewbie, "/admin broadcast hi", MsgNoPermission},
+		{testNewbie, "/calc 10.15.1.14", "255.255.255.0"},
+		{testEngineer, "/admin list", "<code>500</code>"},
+		{testEngineer, "/admin reload", MsgNoPermission},
+		{testAdmin, "/admin", "<code>list</code>"},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			handleUpdate(context.Background(), messageUpdate(c.uid, c.text))
+			sent := tg.flush()
+			text, _ := lastView(sent)
+			assertContains(t, text, c.want)
+			// denied broadcast is not sent to other users
+			for _, m := range sent {
+				if chat, _ := chattableIDs(m); chat != c.uid {
+					t.Errorf("message is sent to %d: %#v", chat, m)
+				}
+			}
+		})
+	}
+	// unknown command is ignored, placeholder is deleted
+	handleUpdate(context.Background(), messageUpdate(testViewer, "/unknown"))
+	for _, c := range tg.flush()[1:] {
+		if _, ok := c.(tgbotapi.DeleteMessageConfig); !ok {
+			t.Errorf("unexpected request for unknown command: %#v", c)
+		}
+	}
+}
+
+// admin mode is kept for next messages
+func TestAdminMode(t *testing.T) {
+	tg, _ := setupTest(t)
+	handleUpdate(context.Background(), messageUpdate(testEngineer, "/admin"))
+	if m := getUserData(testEngineer).Mode; m != "admin" {
+		t.Fatalf("wrong mode: %s", m)
+	}
+	handleUpdate(context.Background(), messageUpdate(testEngineer, "list"))
+	text, _ := lastView(tg.flush())
+	assertContains(t, text, "<code>300</code>")
+	// engineer demoted to viewer leaves admin mode with no permission
+	UsersMu.Lock()
+	Users[testEngineer].Role = "viewer"
+	UsersMu.Unlock()
+	handleUpdate(context.Background(), messageUpdate(testEngineer, "list"))
+	text, _ = lastView(tg.flush())
+	assertContains(t, text, MsgNoPermission)
+}
+
+// callbacks edit view message and are answered
+func TestCallbackUpdate(t *testing.T) {
+	tg, _ := setupTest(t)
+	msg := viewMessage(testViewer)
+	handleUpdate(context.Background(), callbackUpdate(testViewer, msg, "raw edit 192.168.57.10 free"))
+	sent := tg.flush()
+	// keyboard is replaced with waiting button first
+	if m, ok := sent[0].(*tgbotapi.EditMessageReplyMarkupConfig); !ok || m.MessageID != msg.MessageID {
+		t.Fatalf("first request is not waiting keyboard: %#v", sent[0])
+	}
+	text, _ := lastView(sent)
+	assertContains(t, text, "Free ports:")
+	if a, ok := sent[len(sent)-1].(tgbotapi.CallbackConfig); !ok || a.Text != "Done" {
+		t.Errorf("callback is not answered: %#v", sent[len(sent)-1])
+	}
+	if d := getUserData(testViewer); d.LastSwitch != testSwitch {
+		t.Errorf("last switch is not tracked: %+v", d)
+	}
+
+	// search pagination
+	handleUpdate(context.Background(), callbackUpdate(testViewer, msg, "search edit lenina 2"))
+	text, kb := lastView(tg.flush())
+	assertContains(t, text, "Entries found")
+	if b := buttons(kb); b["<"] != "search edit lenina 1" {
+		t.Errorf("wrong pagination: %v", b)
+	}
+
+	// send action answers with new message
+	handleUpdate(context.Background(), callbackUpdate(testViewer, msg, "raw send 192.168.57.10 5 short"))
+	sent = tg.flush()
+	if m, ok := sent[0].(tgbotapi.MessageConfig); !ok || m.Text != "Waiting..." {
+		t.Errorf("first request is not placeholder: %#v", sent[0])
+	}
+
+	// close deletes message
+	handleUpdate(context.Background(), callbackUpdate(testViewer, msg, "close"))
+	sent = tg.flush()
+	if d, ok := sent[0].(tgbotapi.DeleteMessageConfig); !ok || d.MessageID != msg.MessageID {
+		t.Errorf("message is not deleted: %#v", sent[0])
+	}
+}
+
+// buttons with long payloads and expired buttons
+func TestCallbackTokens(t *testing.T) {
+	tg, _ := setupTest(t)
+	msg := viewMessage(testViewer)
+	long := "raw edit 192.168.57.10 5 full " + strings.Repeat("refresh ", 10)
+	u := callbackUpdate(testViewer, msg, long)
+	if len(u.CallbackData()) > MaxCallbackData {
+		t.Fatalf("callback data is too long: %s", u.CallbackData())
+	}
+	handleUpdate(context.Background(), u)
+	text, _ := lastView(tg.flush())
+	assertContains(t, text, "kv 14")
+
+	// tokens are loaded from store after restart
+	u = callbackUpdate(testViewer, msg, long+"restart")
+	callbackMu.Lock()
+	callbackTokens = make(map[string]*callbackPayload)
+	callbackPayloads = make(map[string]string)
+	callbackMu.Unlock()
+	loadCallbacks()
+	if data, err := decodeCallback(u.CallbackData()); err != nil || data != long+"restart" {
+		t.Errorf("token is lost on restart: %q, %v", data, err)
+	}
+
+	u.CallbackQuery.Data = "#1:unknown"
+	handleUpdate(context.Background(), u)
+	sent := tg.flush()
+	if a, ok := sent[0].(tgbotapi.CallbackConfig); !ok || !a.ShowAlert || a.Text != MsgButtonExpired {
+		t.Errorf("expired button is not reported: %#v", sent)
+	}
+}
+
+// export sends document and keeps view message
+func TestCallbackExport(t *testing.T) {
+	tg, _ := setupTest(t)
+	handleUpdate(context.Background(), callbackUpdate(testViewer, viewMessage(testViewer), "export file csv 192.168.57.10"))
+	var doc *tgbotapi.DocumentConfig
+	for _, c := range tg.flush() {
+		switch m := c.(type) {
+		case tgbotapi.DocumentConfig:
+			doc = &m
+		case *tgbotapi.EditMessageTextConfig:
+			t.Errorf("view message is edited: %s", m.Text)
+		}
+	}
+	if doc == nil {
+		t.Fatal("document is not sent")
+	}
+	f, ok := doc.File.(tgbotapi.FileBytes)
+	if !ok || !strings.HasPrefix(f.Name, testSwitch+"_") || !strings.HasSuffix(f.Name, ".csv") {
+		t.Fatalf("wrong file: %#v", doc.File)
+	}
+	assertContains(t, string(f.Bytes), "field,value", "model,DES-3200-28")
+}
+
+// only admins are served in maintenance mode, close button always works
+func TestMaintenance(t *testing.T) {
+	tg, api := setupTest(t)
+	CFGMu.Lock()
+	CFG.MaintenanceMode = true
+	CFGMu.Unlock()
+	handleUpdate(context.Background(), messageUpdate(testViewer, "57.10"))
+	text, _ := lastView(tg.flush())
+	assertContains(t, text, "Bot is under maintenance")
+	if api.requested("GET") {
+		t.Error("api is requested in maintenance mode")
+	}
+	handleUpdate(context.Background(), callbackUpdate(testViewer, viewMessage(testViewer), "close"))
+	if _, ok := tg.flush()[0].(tgbotapi.DeleteMessageConfig); !ok {
+		t.Error("close button does not work in maintenance mode")
+	}
+	handleUpdate(context.Background(), messageUpdate(testAdmin, "57.10"))
+	text, _ = lastView(tg.flush())
+	assertContains(t, text, "DES-3200-28")
+}
+
+// unauthorized users get nothing except access request on start
+func TestUnauthorized(t *testing.T) {
+	tg, api := setupTest(t)
+	handleUpdate(context.Background(), messageUpdate(testStranger, "57.10"))
+	handleUpdate(context.Background(), callbackUpdate(testStranger, viewMessage(testStranger), "raw edit 192.168.57.10"))
+	if sent := tg.flush(); len(sent) > 0 {
+		t.Errorf("unauthorized user got answer: %#v", sent)
+	}
+	if api.requested("GET") {
+		t.Error("api is requested for unauthorized user")
+	}
+}
+
+// access request buttons pressed without permission are answered with alert
+func TestAccessDenied(t *testing.T) {
+	tg, _ := setupTest(t)
+	handleUpdate(context.Background(), callbackUpdate(testEngineer, viewMessage(testEngineer), "user approve 400 viewer"))
+	sent := tg.flush()
+	if len(sent) != 1 {
+		t.Fatalf("request message is changed: %#v", sent)
+	}
+	if a, ok := sent[0].(tgbotapi.CallbackConfig); !ok || !a.ShowAlert || a.Text != MsgNoPermission {
+		t.Errorf("denial is not reported: %#v", sent[0])
+	}
+	if userIsAuthorized(testStranger) {
+		t.Error("user is approved by engineer")
+	}
+}
+
+// readiness probes reuse cached check results
+func TestReadyzCache(t *testing.T) {
+	_, api := setupTest(t)
+	for _, c := range readyChecks {
+		c.mu.Lock()
+		c.time = time.Time{}
+		c.mu.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		// bot is not initialized in tests
+		assertContains(t, w.Body.String(), `"api":"ok"`, `"telegram":"bot is not initialized"`)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.requests) != 1 {
+		t.Errorf("api is checked %d times", len(api.requests))
+	}
+}
+
+// access requests are sent to admins from config and users with admin role,
+// unauthorized admin group members get alert on button press
+func TestAccessRequest(t *testing.T) {
+	tg, _ := setupTest(t)
+	UsersMu.Lock()
+	Users[testEngineer].Role = "admin"
+	UsersMu.Unlock()
+	handleUpdate(context.Background(), messageUpdate(testStranger, "/start"))
+	got := make(map[int64]bool)
+	for _, c := range tg.flush() {
+		if m, ok := c.(tgbotapi.MessageConfig); ok && strings.Contains(m.Text, "</a> requests authorization") {
+			got[m.ChatID] = true
+		}
+	}
+	if !got[testAdmin] || !got[testEngineer] || got[testViewer] {
+		t.Errorf("wrong access request recipients: %v", got)
+	}
+
+	// group member who is not authorized
+	const group, member int64 = -1000, 600
+	CFGMu.Lock()
+	CFG.AdminGroup = group
+	CFGMu.Unlock()
+	handleUpdate(context.Background(), callbackUpdate(member, viewMessage(group), "user approve 400 viewer"))
+	sent := tg.flush()
+	if len(sent) != 1 {
+		t.Fatalf("wrong requests: %#v", sent)
+	}
+	if a, ok := sent[0].(tgbotapi.CallbackConfig); !ok || !a.ShowAlert || a.Text != MsgNoPermission {
+		t.Errorf("denial is not reported: %#v", sent[0])
+	}
+}
+
+// second approve of the same request is not processed
+func TestAccessApproveHandled(t *testing.T) {
+	tg, _ := setupTest(t)
+	handleUpdate(context.Background(), callbackUpdate(testAdmin, viewMessage(testAdmin), "user approve 400 viewer"))
+	text, _ := lastView(tg.flush())
+	assertContains(t, text, "is already handled")
+	if userIsAuthorized(testStranger) {
+		t.Error("user is approved without request")
+	}
+}
+
+// main config which exists but can not be read is an error, not a missing file
+func TestLoadConfigUnreadable(t *testing.T) {
+	setupTest(t)
+	file := filepath.Join(t.TempDir(), "main.yml")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// path through regular file fails with ENOTDIR
+	if _, err := loadConfig(filepath.Join(file, "main.yml")); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+// token longer than page limit is cut to make progress
+func TestSplitHTMLOversizeToken(t *testing.T) {
+	text := `<pre class="` + strings.Repeat("x", 300) + `">code</pre>`
+	done := make(chan []string)
+	go func() { done <- splitHTML(text, 100) }()
+	select {
+	case parts := <-done:
+		for _, p := range parts {
+			if textLen(p) > 100 {
+				t.Errorf("part is too long: %d", textLen(p))
+			}
+		}
+		if got := strings.Join(parts, ""); strings.Count(got, "x") != 300 || !strings.Contains(got, "code") {
+			t.Errorf("text is lost: %v", parts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("split is stuck")
+	}
+}
+
+// api outage time is printed in user timezone
+func TestAPIDownTimezone(t *testing.T) {
+	setupTest(t)
+	UsersMu.Lock()
+	Users[testViewer].Timezone = "Asia/Vladivostok"
+	UsersMu.Unlock()
+	b := getAPI().Breaker
+	// admins are not notified, hook goroutine would outlive test
+	b.Threshold, b.OnChange = 1, nil
+	b.Failure()
+	_, since := b.Open()
+	loc, _ := time.LoadLocation("Asia/Vladivostok")
+	text, _ := rawHandler(userCtx(testViewer), "57.10")
+	assertContains(t, text, "inkotools API is down since "+since.In(loc).Format("15:04"))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// export file formats
+var exportFormats = []string{"txt", "csv", "json"}
+
+// html tags for plain text export
+var reHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// report for export, same data as in message
+type report struct {
+	name string      // file name without extension
+	data interface{} // object for csv and json
+	text string      // message text for txt
+}
+
+// convert message html to plain text
+func htmlToText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(reHTMLTag.ReplaceAllString(s, "")))
+}
+
+// get report for raw request of switch view
+func buildReport(ctx context.Context, raw string) (report, error) {
+	var r report
+	cmd, args := splitArgs(raw)
+	ip := fullIP(cmd, true)
+	if ip == "" {
+		return r, fmt.Errorf("%s is not a switch ip", cmd)
+	}
+	if !can(ctx, "switch") {
+		return r, errors.New(MsgNoPermission)
+	}
+	api := getAPI()
+	r.name = ip
+	action, args := splitArgs(args)
+	switch action {
+	case "":
+		sw, err := api.GetSwitch(ctx, ip)
+		if err != nil {
+			return r, err
+		}
+		r.data, r.text = sw, fmtObj(ctx, sw, "sw.tmpl")
+		return r, nil
+	case "free", "access":
+		get := api.GetFreePorts
+		if action == "access" {
+			get = api.GetAccessPorts
+		}
+		ports, err := get(ctx, ip)
+		if err != nil {
+			return r, err
+		}
+		r.name += "_" + action
+		r.data, r.text = ports, fmtObj(ctx, ports, "port")
+		return r, nil
+	case "log":
+		o, _ := splitArgs(args)
+		offset, _ := strconv.Atoi(o)
+		events, err := api.GetSwitchLogs(ctx, ip, offset, logPageSize)
+		if err != nil {
+			return r, err
+		}
+		r.name += "_log"
+		r.data, r.text = events, fmtObj(ctx, events, "log.tmpl")
+		return r, nil
+	}
+	// port views
+	if _, err := strconv.Atoi(action); err != nil {
+		return r, fmt.Errorf("wrong request: %s", raw)
+	}
+	if !can(ctx, "port") {
+		return r, errors.New(MsgNoPermission)
+	}
+	port := action
+	r.name += "_port" + port
+	if a, o := splitArgs(args); a == "log" {
+		offset, _ := strconv.Atoi(o)
+		events, err := api.GetPortLogs(ctx, ip, port, offset, logPageSize)
+		if err != nil {
+			return r, err
+		}
+		r.name += "_log"
+		r.data, r.text = events, fmtObj(ctx, events, "log.tmpl")
+		return r, nil
+	}
+	style := "short"
+	if strings.Contains(args, "full") {
+		style = "full"
+	}
+	pInfo, err := getPortSummary(ctx, ip, port, style)
+	if err != nil {
+		return r, err
+	}
+	r.text = fmtObj(ctx, pInfo, "port.tmpl")
+	// last event is rendered with template
+	pInfo.LastLogEvent = htmlToText(pInfo.LastLogEvent)
+	r.data = pInfo
+	return r, nil
+}
+
+// field name for csv, json name if set
+func fieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// flatten object to rows of field path and value, slices of simple values are joined with spaces
+func flatten(path string, v reflect.Value, rows *[][2]string) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	join := func(a, b string) string {
+		if a == "" {
+			return b
+		}
+		return a + "." + b
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			*rows = append(*rows, [2]string{path, t.Format(time.RFC3339)})
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			// embedded structs are inlined like in json
+			if f.Anonymous {
+				flatten(path, v.Field(i), rows)
+				continue
+			}
+			flatten(join(path, fieldName(f)), v.Field(i), rows)
+		}
+	case reflect.Slice, reflect.Array:
+		var simple []string
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			switch e.Kind() {
+			case reflect.Struct, reflect.Slice, reflect.Ptr, reflect.Interface, reflect.Map:
+				flatten(join(path, strconv.Itoa(i)), e, rows)
+			default:
+				simple = append(simple, fmt.Sprint(e.Interface()))
+			}
+		}
+		if len(simple) > 0 || v.Len() == 0 {
+			*rows = append(*rows, [2]string{path, strings.Join(simple, " ")})
+		}
+	default:
+		*rows = append(*rows, [2]string{path, fmt.Sprint(v.Interface())})
+	}
+}
+
+// encode object as csv, list is a table with row for each item, other objects are field/value pairs
+func encodeCSV(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Slice {
+		var columns []string
+		index := make(map[string]int)
+		items := make([]map[string]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			var rows [][2]string
+			flatten("", v.Index(i), &rows)
+			items[i] = make(map[string]string)
+			for _, r := range rows {
+				if _, ok := index[r[0]]; !ok {
+					index[r[0]] = len(columns)
+					columns = append(columns, r[0])
+				}
+				items[i][r[0]] = r[1]
+			}
+		}
+		w.Write(columns)
+		for _, item := range items {
+			line := make([]string, len(columns))
+			for i, c := range columns {
+				line[i] = item[c]
+			}
+			w.Write(line)
+		}
+	} else {
+		var rows [][2]string
+		flatten("", v, &rows)
+		w.Write([]string{"field", "value"})
+		for _, r := range rows {
+			w.Write(r[:])
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// encode report in export format
+func encodeReport(r report, format string) ([]byte, error) {
+	switch format {
+	case "txt":
+		return []byte(htmlToText(r.text) + "\n"), nil
+	case "csv":
+		return encodeCSV(r.data)
+	case "json":
+		return json.MarshalIndent(r.data, "", "  ")
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+// keyboard with export formats for raw request
+func exportKeyboard(ctx context.Context, raw string) tgbotapi.InlineKeyboardMarkup {
+	var row []map[string]string
+	for _, f := range exportFormats {
+		row = append(row, map[string]string{f: fmt.Sprintf("export file %s %s", f, raw)})
+	}
+	return genKeyboard([][]map[string]string{
+		row,
+		{
+			{tr(ctx, "back"): fmt.Sprintf("raw edit %s", raw)},
+			{tr(ctx, "close"): "close"},
+		},
+	})
+}
+
+// export button for raw request, nil if user can not export
+func exportButton(ctx context.Context, raw string) map[string]string {
+	if !can(ctx, "export") {
+		return nil
+	}
+	return map[string]string{tr(ctx, "export"): fmt.Sprintf("export menu %s", raw)}
+}
+
+// send report for raw request as document
+func sendReport(ctx context.Context, chat int64, format string, raw string) error {
+	r, err := buildReport(ctx, raw)
+	if err != nil {
+		return err
+	}
+	data, err := encodeReport(r, format)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.%s", r.name, localTime(ctx, time.Now()).Format("20060102-150405"), format)
+	doc := tgbotapi.NewDocument(chat, tgbotapi.FileBytes{Name: name, Bytes: data})
+	doc.Caption = raw
+	doc.ReplyMarkup = closeButton(ctx)
+	if _, err = Telegram.Send(doc); err != nil {
+		logError("[export] Send file failed", append(userFields(ctxUID(ctx)), "error", err)...)
+	}
+	return err
+}
+
+// export buttons handler, empty result keeps message unchanged
+func exportHandler(ctx context.Context, msg *tgbotapi.Message, action string, args string) string {
+	if !can(ctx, "export") {
+		return tr(ctx, MsgNoPermission)
+	}
+	switch action {
+	case "menu":
+		editKeyboard(msg, exportKeyboard(ctx, args))
+		return ""
+	case "file":
+		format, raw := splitArgs(args)
+		if err := sendReport(ctx, msg.Chat.ID, format, raw); err != nil {
+			res := fmtErr(html.EscapeString(tr(ctx, errText(ctx, err))))
+			sendAlert(msg.Chat.ID, res)
+			return res
+		}
+		return ""
+	}
+	return fmtErr(tr(ctx, "Wrong action"))
+}
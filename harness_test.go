@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ping/ping"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// test users, admin is from main config, others from users config, newbie has no role
+const (
+	testAdmin    int64 = 100
+	testEngineer int64 = 200
+	testViewer   int64 = 300
+	testStranger int64 = 400
+	testNewbie   int64 = 500
+)
+
+// test switch with recorded responses in testdata/api
+const testSwitch string = "192.168.57.10"
+
+// fakeTelegram - telegram sender recording all requests, sent messages get sequential ids
+type fakeTelegram struct {
+	mu     sync.Mutex
+	nextID int
+	sent   []tgbotapi.Chattable
+}
+
+// chat and message ids of request
+func chattableIDs(c tgbotapi.Chattable) (chat int64, msg int) {
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		return m.ChatID, 0
+	case tgbotapi.DocumentConfig:
+		return m.ChatID, 0
+	case *tgbotapi.EditMessageTextConfig:
+		return m.ChatID, m.MessageID
+	case *tgbotapi.EditMessageReplyMarkupConfig:
+		return m.ChatID, m.MessageID
+	case tgbotapi.DeleteMessageConfig:
+		return m.ChatID, m.MessageID
+	}
+	return 0, 0
+}
+
+// Send - record request, new messages get new id, edited ones keep it
+func (f *fakeTelegram) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	chat, id := chattableIDs(c)
+	if id == 0 {
+		f.nextID++
+		id = f.nextID
+	}
+	res := tgbotapi.Message{MessageID: id, Chat: &tgbotapi.Chat{ID: chat}, Date: int(time.Now().Unix())}
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		res.Text = m.Text
+		if kb, ok := m.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+			res.ReplyMarkup = &kb
+		}
+	case *tgbotapi.EditMessageTextConfig:
+		res.Text = m.Text
+		res.ReplyMarkup = m.ReplyMarkup
+	}
+	return res, nil
+}
+
+// Request - record request
+func (f *fakeTelegram) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// get and forget recorded requests
+func (f *fakeTelegram) flush() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := f.sent
+	f.sent = nil
+	return res
+}
+
+// last message text and keyboard from sent or edited messages
+func lastView(sent []tgbotapi.Chattable) (string, tgbotapi.InlineKeyboardMarkup) {
+	for i := len(sent) - 1; i >= 0; i-- {
+		switch m := sent[i].(type) {
+		case tgbotapi.MessageConfig:
+			kb, _ := m.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+			return m.Text, kb
+		case *tgbotapi.EditMessageTextConfig:
+			var kb tgbotapi.InlineKeyboardMarkup
+			if m.ReplyMarkup != nil {
+				kb = *m.ReplyMarkup
+			}
+			return m.Text, kb
+		}
+	}
+	return "", tgbotapi.InlineKeyboardMarkup{}
+}
+
+// decoded callback data of keyboard buttons by button text
+func buttons(kb tgbotapi.InlineKeyboardMarkup) map[string]string {
+	res := make(map[string]string)
+	for _, row := range kb.InlineKeyboard {
+		for _, b := range row {
+			if b.CallbackData != nil {
+				res[b.Text], _ = decodeCallback(*b.CallbackData)
+			}
+		}
+	}
+	return res
+}
+
+// fakeAPI - inkotools api serving recorded responses from testdata/api,
+// response for GET or POST /sw/IP/ports/5/ is in sw/IP/ports/5.json, for other methods
+// in sw/IP/ports/5.METHOD.json, query is ignored, missing responses are 404 errors
+type fakeAPI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string // method, uri and body of received requests
+}
+
+// start fake api, it is stopped after test
+func newFakeAPI(t *testing.T) *fakeAPI {
+	f := &fakeAPI{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// fixture file for request
+func fixtureName(method string, path string) string {
+	name := filepath.FromSlash(strings.Trim(path, "/"))
+	if method != http.MethodGet && method != http.MethodPost {
+		name += "." + method
+	}
+	return filepath.Join("testdata", "api", name+".json")
+}
+
+// serve recorded response
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	data, err := os.ReadFile(fixtureName(r.Method, r.URL.Path))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"detail": "Not found: %s"}`, r.URL.Path)
+		return
+	}
+	w.Write(data)
+}
+
+// check if request with prefix was received
+func (f *fakeAPI) requested(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// set globals for test: config, users, templates, user data store, callback tokens, access requests, fake telegram and fake api
+func setupTest(t *testing.T) (*fakeTelegram, *fakeAPI) {
+	t.Helper()
+	Log = NewLogger(io.Discard)
+	api := newFakeAPI(t)
+	tg := &fakeTelegram{}
+	Telegram = tg
+	tpl, err := loadTemplates(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openStore("gob", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	CFGMu.Lock()
+	CFG = Config{
+		Admin:          testAdmin,
+		InkoToolsAPI:   api.URL,
+		MaintenanceMsg: "Bot is under maintenance. Try later.",
+		Timezone:       "UTC",
+		Language:       "en",
+	}
+	TPL = tpl
+	initAPI()
+	API.Backoff = 0
+	CFGMu.Unlock()
+	UsersMu.Lock()
+	Users = map[int64]*UserConfig{
+		testEngineer: {Name: "engineer", Role: "engineer"},
+		testViewer:   {Name: "viewer", Role: "viewer"},
+		testNewbie:   {Name: "newbie"},
+	}
+	UsersMu.Unlock()
+	DataMu.Lock()
+	Data = make(map[int64]*UserData)
+	DataMu.Unlock()
+	Storage = store
+	// callback tokens are saved in store of each test
+	callbackMu.Lock()
+	callbackTokens = make(map[string]*callbackPayload)
+	callbackPayloads = make(map[string]string)
+	callbackMu.Unlock()
+	AccessRequestsMu.Lock()
+	AccessRequests = make(map[int64]*accessRequest)
+	AccessRequestsMu.Unlock()
+	Audit = nil
+	Pingers = make(map[int64]*ping.Pinger)
+	return tg, api
+}
+
+// context of update from user
+func userCtx(uid int64) context.Context {
+	return withUID(context.Background(), uid)
+}
+
+// text message update, first word with slash is command
+func messageUpdate(uid int64, text string) tgbotapi.Update {
+	m := &tgbotapi.Message{
+		MessageID: 1000,
+		From:      &tgbotapi.User{ID: uid, LanguageCode: "en"},
+		Chat:      &tgbotapi.Chat{ID: uid},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		cmd, _ := splitArgs(text)
+		m.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+	}
+	return tgbotapi.Update{Message: m}
+}
+
+// button press update for message
+func callbackUpdate(uid int64, msg tgbotapi.Message, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    &tgbotapi.User{ID: uid, LanguageCode: "en"},
+		Message: &msg,
+		Data:    encodeCallback(data),
+	}}
+}
+
+// message with bot view, target for callbacks
+func viewMessage(uid int64) tgbotapi.Message {
+	return tgbotapi.Message{MessageID: 500, Chat: &tgbotapi.Chat{ID: uid}, Date: int(time.Now().Unix())}
+}
+
+// fail test if text does not contain all parts
+func assertContains(t *testing.T, text string, parts ...string) {
+	t.Helper()
+	for _, p := range parts {
+		if !strings.Contains(text, p) {
+			t.Errorf("%q not found in:\n%s", p, text)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultHealthStuckAfter - default processing time of one update to consider update loop stuck
+const DefaultHealthStuckAfter time.Duration = 5 * time.Minute
+
+// readiness check timeout
+const readyTimeout time.Duration = 5 * time.Second
+
+// Updates - telegram updates dispatcher, used by health checks
+var Updates *Dispatcher
+
+// Servers - running http servers
+var Servers []*http.Server
+
+// start http server in background, errors are logged
+func serveHTTP(port string, handler http.Handler) *http.Server {
+	srv := &http.Server{Addr: ":" + port, Handler: handler}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logError("[http] Serve failed", "port", port, "error", err)
+		}
+	}()
+	Servers = append(Servers, srv)
+	return srv
+}
+
+// add health endpoints to mux
+func handleHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+}
+
+// write check results as json, any failed check sets 503 status
+func writeChecks(w http.ResponseWriter, checks map[string]string) {
+	status := "ok"
+	code := http.StatusOK
+	for _, res := range checks {
+		if res != "ok" {
+			status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
+
+// liveness: process is alive and update loop is not stuck
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	stuckAfter := getConfig().HealthStuckAfter
+	if stuckAfter <= 0 {
+		stuckAfter = DefaultHealthStuckAfter
+	}
+	checks := map[string]string{"updates": "ok"}
+	if Updates != nil {
+		if t := Updates.Longest(); t > stuckAfter {
+			checks["updates"] = fmt.Sprintf("update is processed for %v", t.Round(time.Second))
+		}
+	}
+	writeChecks(w, checks)
+}
+
+// readiness check results are cached to keep frequent probes cheap
+const readyCacheTTL time.Duration = 10 * time.Second
+
+// readyCheck - readiness check with cached result, only one check runs at time
+type readyCheck struct {
+	f       func(ctx context.Context) error
+	mu      sync.Mutex
+	res     string
+	time    time.Time     // time of last result
+	running chan struct{} // closed when running check is done, nil if no check is running
+}
+
+// readiness checks of external services
+var readyChecks = map[string]*readyCheck{
+	"telegram": {f: checkTelegram},
+	"api":      {f: checkAPI},
+}
+
+// get cached result or wait for check, hung check is reported as timeout
+// and keeps running in background until its own deadline
+func (c *readyCheck) result(ctx context.Context) string {
+	c.mu.Lock()
+	if !c.time.IsZero() && time.Since(c.time) < readyCacheTTL {
+		defer c.mu.Unlock()
+		return c.res
+	}
+	if c.running == nil {
+		c.running = make(chan struct{})
+		go c.run()
+	}
+	done := c.running
+	c.mu.Unlock()
+	select {
+	case <-done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.res
+	case <-ctx.Done():
+		return "timeout"
+	}
+}
+
+// run check with timeout and save result
+func (c *readyCheck) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	res := "ok"
+	if err := c.f(ctx); err != nil {
+		res = err.Error()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.res, c.time = res, time.Now()
+	close(c.running)
+	c.running = nil
+}
+
+// request getMe with deadline, bot api client methods have no context
+func checkTelegram(ctx context.Context) error {
+	if Bot == nil {
+		return errors.New("bot is not initialized")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(tgbotapi.APIEndpoint, Bot.Token, "getMe"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := Bot.Client.Do(req)
+	if err != nil {
+		// url with bot token is not shown
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	var res tgbotapi.APIResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.Ok {
+		return errors.New(res.Description)
+	}
+	return nil
+}
+
+// ping inkotools api
+func checkAPI(ctx context.Context) error {
+	api := getAPI()
+	if api == nil {
+		return errors.New("api client is not initialized")
+	}
+	err := api.Ping(ctx)
+	// show underlying network error
+	if u := errors.Unwrap(err); u != nil {
+		return fmt.Errorf("%v: %v", err, u)
+	}
+	return err
+}
+
+// readiness: telegram and inkotools api are reachable, templates are loaded
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	checks := map[string]string{"templates": "ok"}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range readyChecks {
+		wg.Add(1)
+		go func(name string, c *readyCheck) {
+			defer wg.Done()
+			res := c.result(ctx)
+			mu.Lock()
+			checks[name] = res
+			mu.Unlock()
+		}(name, c)
+	}
+	CFGMu.RLock()
+	if TPL == nil || len(TPL.Templates()) == 0 {
+		checks["templates"] = "templates are not loaded"
+	}
+	CFGMu.RUnlock()
+	wg.Wait()
+	writeChecks(w, checks)
+}
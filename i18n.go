@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultLanguage - language for users without language in config and telegram profile
+const DefaultLanguage string = "en"
+
+// Catalogs - translations of bot messages by language, english text is the key,
+// missing translations fall back to english
+var Catalogs = map[string]map[string]string{
+	"en": {},
+	"ru": catalogRU,
+}
+
+// list of supported languages
+func languages() []string {
+	res := make([]string, 0, len(Catalogs))
+	for lang := range Catalogs {
+		res = append(res, lang)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// get supported language from IETF language tag, e.g. ru for ru-RU, empty if not supported
+func parseLang(code string) string {
+	lang := strings.ToLower(strings.SplitN(strings.ReplaceAll(code, "_", "-"), "-", 2)[0])
+	if _, ok := Catalogs[lang]; ok {
+		return lang
+	}
+	return ""
+}
+
+// get language from code or default language from main config
+func pickLang(code string) string {
+	if lang := parseLang(code); lang != "" {
+		return lang
+	}
+	if lang := parseLang(getConfig().Language); lang != "" {
+		return lang
+	}
+	return DefaultLanguage
+}
+
+// get user language, user config overrides language from telegram profile
+func userLang(uid int64) string {
+	var code string
+	UsersMu.RLock()
+	if u, ok := Users[uid]; ok {
+		code = u.Language
+	}
+	UsersMu.RUnlock()
+	if parseLang(code) == "" {
+		DataMu.Lock()
+		if d, ok := Data[uid]; ok {
+			code = d.Lang
+		}
+		DataMu.Unlock()
+	}
+	return pickLang(code)
+}
+
+// remember language code from telegram profile for messages sent outside of updates
+func setUserLang(uid int64, code string) {
+	if code == "" {
+		return
+	}
+	DataMu.Lock()
+	defer DataMu.Unlock()
+	if d, ok := Data[uid]; ok {
+		d.Lang = code
+	}
+}
+
+// translate message to language
+func translate(lang string, msg string) string {
+	if t, ok := Catalogs[lang][msg]; ok && t != "" {
+		return t
+	}
+	return msg
+}
+
+// translate message to language of user from context
+func tr(ctx context.Context, msg string) string {
+	return translate(userLang(ctxUID(ctx)), msg)
+}
+
+// translate format string to language of user from context and print args with it
+func trf(ctx context.Context, format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(ctx, format), args...)
+}
+
+// check if text contains message in any language
+func containsMsg(text string, msg string) bool {
+	for lang := range Catalogs {
+		if strings.Contains(text, translate(lang, msg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// set bot commands for all languages, english ones are default
+func setBotCommands() {
+	if _, err := Telegram.Request(tgbotapi.NewSetMyCommands(botCommands(DefaultLanguage)...)); err != nil {
+		logError("[init] Set commands failed", "error", err)
+	}
+	for _, lang := range languages() {
+		if lang == "en" {
+			continue
+		}
+		cmd := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), lang, botCommands(lang)...)
+		if _, err := Telegram.Request(cmd); err != nil {
+			logError("[init] Set commands failed", "lang", lang, "error", err)
+		}
+	}
+}
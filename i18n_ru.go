@@ -0,0 +1,173 @@
+package main
+
+// russian translations of bot messages and template labels
+var catalogRU = map[string]string{
+	// help and commands
+	HELPADMIN: `
+<code>list</code> - список пользователей
+<code>add ID [NAME]</code> - добавить пользователя с id <b><i>ID</i></b> и необязательным комментарием <b><i>NAME</i></b>
+<code>del ID</code> - удалить пользователя с id <b><i>ID</i></b>
+<code>role ID ROLE</code> - назначить роль <b><i>ROLE</i></b> (viewer, engineer или admin) пользователю с id <b><i>ID</i></b>
+<code>send ID TEXT</code> - отправить сообщение <b><i>TEXT</i></b> пользователю с id <b><i>ID</i></b>
+<code>broadcast TEXT</code> - отправить сообщение <b><i>TEXT</i></b> всем пользователям
+<code>reload</code> - перечитать конфигурацию из файла
+<code>maintenance [on|off]</code> - показать или переключить режим обслуживания, переключение сохраняется до изменения опции maintenance в файле конфигурации
+<code>cache [flush]</code> - статистика кэша api или его очистка
+<code>log [SUBSYSTEM] [LEVEL|reset]</code> - показать или изменить уровень логирования, при необходимости только для <b><i>SUBSYSTEM</i></b> (api, ping, ...)
+<code>audit [IP] [user ID] [since 2d]</code> - последние действия пользователей с фильтром по <b><i>IP</i></b> коммутатора, <b><i>ID</i></b> пользователя и периоду
+`,
+	"print help":             "справка",
+	"last requests":          "последние запросы",
+	"get switch summary":     "информация о коммутаторе",
+	"get port info":          "информация о порте",
+	"get free ports":         "свободные порты",
+	"ping":                   "пинг",
+	"ip calc":                "ip калькулятор",
+	"admin mode":             "режим администратора",
+	"switch info mode":       "режим информации о коммутаторах",
+	"Usage: <code>%s</code>": "Использование: <code>%s</code>",
+
+	// common messages
+	MsgNoPermission:                         "Недостаточно прав для этого действия",
+	MsgRestarting:                           "Бот перезапускается, повторите через минуту.",
+	"Bot is under maintenance. Try later.":  "Бот на обслуживании. Попробуйте позже.",
+	"Waiting...":                            "Ожидание...",
+	"Done":                                  "Готово",
+	"Pages are expired, repeat the request": "Страницы устарели, повторите запрос",
+	MsgButtonExpired:                        "Кнопка устарела, повторите запрос",
+	"\n<i>Updated:</i> <code>%s</code>":     "\n<i>Обновлено:</i> <code>%s</code>",
+	"History is empty":                      "История пуста",
+	"Last requests:":                        "Последние запросы:",
+	"\nLast switch: <code>%s</code>":        "\nПоследний коммутатор: <code>%s</code>",
+	"%s is a client ip, not a switch ip":    "%s - ip клиента, а не коммутатора",
+	"%s is not a switch ip":                 "%s - не ip коммутатора",
+	"[calc] wrong ip: %s":                   "[calc] неверный ip: %s",
+	"Search for '%s': %v":                   "Поиск '%s': %v",
+	"Free ports:":                           "Свободные порты:",
+	"Not found":                             "Не найдено",
+	"No access ports found":                 "Абонентские порты не найдены",
+	"Transit ports are not supported":       "Транзитные порты не поддерживаются",
+	"events [%d - %d]:":                     "события [%d - %d]:",
+	"Impossible to ping switch ip without violating network conception. Use raw mode for availability checks.": "Пинг ip коммутатора нарушает концепцию сети. Для проверки доступности используйте обычный режим.",
+	MsgCannotDelete: "<b>Бот не может удалять сообщения старше 48 часов!</b> \n\n" +
+		"<i>Это ограничение telegram api. Вы можете удалить это сообщение вручную.</i> \n\n" +
+		"<code>https://core.telegram.org/bots/api#deletemessage</code>",
+
+	// buttons
+	"close":          "закрыть",
+	"refresh":        "обновить",
+	"repeat":         "повторить",
+	"short":          "кратко",
+	"full":           "подробно",
+	"switch log":     "лог коммутатора",
+	"port log":       "лог порта",
+	"free ports":     "свободные порты",
+	"access ports":   "абонентские порты",
+	"clear counters": "сбросить счетчики",
+	"export":         "экспорт",
+	"back":           "назад",
+
+	// access requests
+	"User <a href=\"tg://user?id=%d\">%s</a> requests authorization:\nid: <code>%d</code>": "Пользователь <a href=\"tg://user?id=%d\">%s</a> запрашивает доступ:\nid: <code>%d</code>",
+	"approve as viewer":   "одобрить как viewer",
+	"approve as engineer": "одобрить как engineer",
+	"deny":                "отклонить",
+	"Your request is already sent. Try again later.":                             "Ваш запрос уже отправлен. Попробуйте позже.",
+	"Your request is accepted. Waiting confirmation from admin.":                 "Ваш запрос принят. Ожидайте подтверждения администратора.",
+	"Your request is denied.":                                                    "Ваш запрос отклонен.",
+	"Reason:":                                                                    "Причина:",
+	"You are added to authorized users list.":                                    "Вы добавлены в список пользователей.",
+	"You are removed from authorized users list.":                                "Вы удалены из списка пользователей.",
+	"User <code>%d</code> <b>%s</b> is already authorized.":                      "Пользователь <code>%d</code> <b>%s</b> уже авторизован.",
+	"Access request of user <code>%d</code> is already handled or expired.":      "Запрос доступа пользователя <code>%d</code> уже обработан или устарел.",
+	"User <code>%d</code> <b>%s</b> approved as <code>%s</code> by <i>%s</i>.":   "Пользователь <code>%d</code> <b>%s</b> одобрен как <code>%s</code>, <i>%s</i>.",
+	"User <code>%d</code> denied by <i>%s</i>.":                                  "Пользователю <code>%d</code> отказано, <i>%s</i>.",
+	"Send denial reason for user <code>%d</code>, it will be forwarded to user.": "Отправьте причину отказа пользователю <code>%d</code>, она будет ему переслана.",
+	"Cancelled":          "Отменено",
+	"Nothing to comment": "Нечего комментировать",
+
+	// admin commands
+	"Wrong uid":                               "Неверный uid",
+	"Wrong role":                              "Неверная роль",
+	"Wrong role, use one of: %s, %s, %s":      "Неверная роль, используйте одну из: %s, %s, %s",
+	"Wrong action":                            "Неверное действие",
+	"Wrong arguments":                         "Неверные аргументы",
+	"Wrong duration":                          "Неверный период",
+	"Wrong ip":                                "Неверный ip",
+	"Missing value for %s":                    "Не указано значение для %s",
+	"Nothing to do":                           "Нечего делать",
+	"User <code>%d</code> <b>%s</b> added.":   "Пользователь <code>%d</code> <b>%s</b> добавлен.",
+	"User <code>%d</code> <b>%s</b> removed.": "Пользователь <code>%d</code> <b>%s</b> удален.",
+	"User <code>%d</code> <b>%s</b> role: <code>%s</code>": "Пользователь <code>%d</code> <b>%s</b> роль: <code>%s</code>",
+	"Message sent":                     "Сообщение отправлено",
+	"Message not sent: %v":             "Сообщение не отправлено: %v",
+	"empty message":                    "пустое сообщение",
+	"%d failed: %v\n":                  "%d ошибка: %v\n",
+	"Failed":                           "Ошибка",
+	"Config reloaded, nothing changed": "Конфигурация перечитана, изменений нет",
+	"Config reloaded:\n%s":             "Конфигурация перечитана:\n%s",
+	"Config reload failed, old config is kept:%s":         "Не удалось перечитать конфигурацию, используется старая:%s",
+	"Cache is disabled":                                   "Кэш отключен",
+	"Cache entries: <code>%d</code>\n":                    "Записей в кэше: <code>%d</code>\n",
+	"Cache flushed":                                       "Кэш очищен",
+	"Maintenance: %v":                                     "Обслуживание: %v",
+	"Log level: <code>%s</code>\n":                        "Уровень логирования: <code>%s</code>\n",
+	"Audit log is disabled":                               "Журнал аудита отключен",
+	"No entries found":                                    "Записи не найдены",
+	"&#9888; inkotools API is down since <code>%s</code>": "&#9888; inkotools API недоступен с <code>%s</code>",
+	"inkotools API is down since %s":                      "inkotools API недоступен с %s",
+	"&#9989; inkotools API is up again, was down since <code>%s</code>": "&#9989; inkotools API снова доступен, был недоступен с <code>%s</code>",
+
+	// template labels
+	"ip":                        "ip",
+	"mac":                       "mac",
+	"model":                     "модель",
+	"location":                  "адрес",
+	"status":                    "статус",
+	"available":                 "доступен",
+	"unavailable":               "недоступен",
+	"Switch is unavailable!":    "Коммутатор недоступен!",
+	"Entries found":             "Найдено записей",
+	"Page":                      "Страница",
+	"%d entries per page":       "%d записей на странице",
+	"No events":                 "Нет событий",
+	"ADDRESS":                   "АДРЕС",
+	"NETMASK":                   "МАСКА",
+	"GATEWAY":                   "ШЛЮЗ",
+	"PREFIX":                    "ПРЕФИКС",
+	"Port":                      "Порт",
+	"Pair":                      "Пара",
+	"Description":               "Описание",
+	"Temperature":               "Температура",
+	"Voltage":                   "Напряжение",
+	"Bias Current":              "Ток смещения",
+	"TX Power":                  "Мощность TX",
+	"RX Power":                  "Мощность RX",
+	"MAC learning":              "Изучение MAC",
+	"Autodowngrade":             "Autodowngrade",
+	"enabled":                   "включено",
+	"disabled":                  "выключено",
+	"Bandwidth limits":          "Ограничения скорости",
+	"Counters":                  "Счетчики",
+	"port":                      "порт",
+	"client":                    "клиент",
+	"Total":                     "Всего",
+	"Now":                       "Сейчас",
+	"RX Errors":                 "Ошибки RX",
+	"TX Errors":                 "Ошибки TX",
+	"VLAN untagged":             "VLAN untagged",
+	"VLAN tagged":               "VLAN tagged",
+	"no rules":                  "нет правил",
+	"Multicast":                 "Мультикаст",
+	"No multicast source ports": "Нет портов источника мультикаста",
+	"Filters":                   "Фильтры",
+	"no limits":                 "без ограничений",
+	"Groups":                    "Группы",
+	"empty":                     "пусто",
+	"MAC Table":                 "Таблица MAC",
+	"ARP Table":                 "Таблица ARP",
+	"LinkDown":                  "LinkDown",
+	"times in last 24h":         "раз за последние 24ч",
+	"Last event":                "Последнее событие",
+	"%d entries, pagination not supported yet...": "%d записей, постраничный вывод пока не поддерживается...",
+}
@@ -0,0 +1,102 @@
+package inkotools
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBreakerThreshold - default consecutive failures to open circuit
+const DefaultBreakerThreshold = 5
+
+// DefaultBreakerCooldown - default time before trial request in open state
+const DefaultBreakerCooldown = 30 * time.Second
+
+// Breaker - circuit breaker for api requests
+type Breaker struct {
+	Threshold int                              // consecutive failures to open circuit
+	Cooldown  time.Duration                    // time before trial request in open state
+	OnChange  func(open bool, since time.Time) // called in goroutine when circuit opens or closes
+
+	mu        sync.Mutex
+	failures  int       // consecutive failures count
+	openSince time.Time // zero if circuit is closed
+	trialAt   time.Time // time of next trial request in open state
+	trial     bool      // trial request is running
+}
+
+// NewBreaker - create closed circuit breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow - check if request is allowed, returns open circuit error otherwise
+func (b *Breaker) Allow() *Error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openSince.IsZero() {
+		return nil
+	}
+	// let one trial request through after cooldown
+	if !b.trial && !time.Now().Before(b.trialAt) {
+		b.trial = true
+		return nil
+	}
+	return &Error{Kind: ErrCircuitOpen, Since: b.openSince}
+}
+
+// Success - register successful request, closes circuit
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	since := b.openSince
+	b.failures = 0
+	b.openSince = time.Time{}
+	b.trial = false
+	b.mu.Unlock()
+	if !since.IsZero() {
+		b.notify(false, since)
+	}
+}
+
+// Failure - register failed request, opens circuit on threshold
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	now := time.Now()
+	b.failures++
+	b.trial = false
+	if !b.openSince.IsZero() {
+		// trial request failed, wait for next one
+		b.trialAt = now.Add(b.Cooldown)
+		b.mu.Unlock()
+		return
+	}
+	if b.failures < b.Threshold {
+		b.mu.Unlock()
+		return
+	}
+	b.openSince = now
+	b.trialAt = now.Add(b.Cooldown)
+	b.mu.Unlock()
+	b.notify(true, now)
+}
+
+// Release - register request without result, e.g. cancelled by caller, so next request
+// can be a trial in open state
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Open - check if circuit is open, returns time when it was opened
+func (b *Breaker) Open() (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openSince.IsZero(), b.openSince
+}
+
+// call state change hook
+func (b *Breaker) notify(open bool, since time.Time) {
+	if b.OnChange != nil {
+		go b.OnChange(open, since)
+	}
+}
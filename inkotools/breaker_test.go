@@ -0,0 +1,137 @@
+package inkotools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// circuit opens on threshold, lets one trial through after cooldown and closes on success
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, 10*time.Millisecond)
+	b.Failure()
+	if b.Allow() != nil {
+		t.Fatal("circuit is opened before threshold")
+	}
+	b.Failure()
+	if err := b.Allow(); err == nil || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("circuit is not opened on threshold: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if b.Allow() != nil {
+		t.Fatal("trial is not allowed after cooldown")
+	}
+	if b.Allow() == nil {
+		t.Fatal("second trial is allowed")
+	}
+	b.Success()
+	if open, _ := b.Open(); open || b.Allow() != nil {
+		t.Fatal("circuit is not closed after successful trial")
+	}
+}
+
+// released trial is given to next request
+func TestBreakerRelease(t *testing.T) {
+	b := NewBreaker(1, 0)
+	b.Failure()
+	if b.Allow() != nil {
+		t.Fatal("trial is not allowed")
+	}
+	b.Release()
+	if b.Allow() != nil {
+		t.Fatal("trial is not allowed after release")
+	}
+	if open, _ := b.Open(); !open {
+		t.Fatal("circuit is closed by release")
+	}
+}
+
+// test client with breaker and state changes recorded
+func newBreakerClient(t *testing.T, h http.HandlerFunc) (*Client, func() []bool) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL)
+	c.Cache = nil
+	c.Retries = 0
+	c.Timeout = 20 * time.Millisecond
+	c.Breaker = NewBreaker(1, 10*time.Millisecond)
+	var mu sync.Mutex
+	var changes []bool
+	c.Breaker.OnChange = func(open bool, since time.Time) {
+		mu.Lock()
+		changes = append(changes, open)
+		mu.Unlock()
+	}
+	return c, func() []bool {
+		// hook is called in goroutine
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return changes
+	}
+}
+
+// trial cancelled by caller does not leave circuit open forever
+func TestClientTrialCancelled(t *testing.T) {
+	var down, slow int32 = 1, 0
+	c, _ := newBreakerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Write([]byte(`{"data": {"ip": "192.168.57.10"}}`))
+	})
+	if _, err := c.GetSwitch(context.Background(), "192.168.57.10"); err == nil {
+		t.Fatal("no error from unavailable api")
+	}
+	if open, _ := c.Breaker.Open(); !open {
+		t.Fatal("circuit is not opened")
+	}
+	atomic.StoreInt32(&down, 0)
+	atomic.StoreInt32(&slow, 1)
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetSwitch(ctx, "192.168.57.10"); err == nil {
+		t.Fatal("no error from cancelled trial")
+	}
+	atomic.StoreInt32(&slow, 0)
+	if _, err := c.GetSwitch(context.Background(), "192.168.57.10"); err != nil {
+		t.Fatalf("request after cancelled trial failed: %v", err)
+	}
+	if open, _ := c.Breaker.Open(); open {
+		t.Fatal("circuit is not closed")
+	}
+}
+
+// hung api opens circuit, timed out trial keeps it open
+func TestClientTimeout(t *testing.T) {
+	c, changes := newBreakerClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	})
+	_, err := c.GetSwitch(context.Background(), "192.168.57.10")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if open, _ := c.Breaker.Open(); !open {
+		t.Fatal("circuit is not opened by timeout")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err = c.GetSwitch(context.Background(), "192.168.57.10"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("trial is not sent: %v", err)
+	}
+	if open, _ := c.Breaker.Open(); !open {
+		t.Fatal("circuit is closed by timed out trial")
+	}
+	if ch := changes(); len(ch) != 1 || !ch[0] {
+		t.Errorf("wrong state changes: %v", ch)
+	}
+}
@@ -0,0 +1,138 @@
+package inkotools
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL - default cache ttl for endpoint classes, other endpoints are not cached.
+// Switch class (model, location and availability status) is not cached by default,
+// status is link state and cached one would hide switch going down
+var DefaultCacheTTL = map[string]time.Duration{
+	"access_ports": time.Hour,        // list of access port numbers
+	"multicast":    10 * time.Minute, // multicast vlan source and member ports
+	"ipcalc":       24 * time.Hour,   // ip address summary
+}
+
+// cache entries limit, expired entries are pruned on overflow
+const cacheMaxEntries = 10000
+
+// CacheStats - cache hits and misses for endpoint class
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// HitRate - percent of requests served from cache
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) * 100 / float64(s.Hits+s.Misses)
+}
+
+// cached response data
+type cacheEntry struct {
+	data    json.RawMessage
+	expires time.Time
+}
+
+// Cache - in-memory ttl cache for api responses
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry  // key is endpoint
+	stats   map[string]*CacheStats // key is endpoint class
+}
+
+// NewCache - create empty cache
+func NewCache() *Cache {
+	return &Cache{
+		entries: make(map[string]cacheEntry),
+		stats:   make(map[string]*CacheStats),
+	}
+}
+
+// get valid entry data and count hit or miss
+func (c *Cache) get(class string, endpoint string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[class]
+	if !ok {
+		s = &CacheStats{}
+		c.stats[class] = s
+	}
+	e, ok := c.entries[endpoint]
+	if !ok || time.Now().After(e.expires) {
+		s.Misses++
+		return nil, false
+	}
+	s.Hits++
+	return e.data, true
+}
+
+// save entry data with ttl
+func (c *Cache) set(endpoint string, data json.RawMessage, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= cacheMaxEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[endpoint] = cacheEntry{data: data, expires: now.Add(ttl)}
+}
+
+// remove entry
+func (c *Cache) delete(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, endpoint)
+}
+
+// Len - number of cached entries, including expired ones
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stats - copy of cache stats, key is endpoint class
+func (c *Cache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]CacheStats, len(c.stats))
+	for class, s := range c.stats {
+		res[class] = *s
+	}
+	return res
+}
+
+// Flush - remove all entries and reset stats
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.stats = make(map[string]*CacheStats)
+}
+
+// context key type
+type ctxKey int
+
+// bypass cache context key
+const noCacheKey ctxKey = iota
+
+// NoCache - get context for requests which bypass cache, fresh responses are still cached
+func NoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey, true)
+}
+
+// check if context bypasses cache
+func noCache(ctx context.Context) bool {
+	v, _ := ctx.Value(noCacheKey).(bool)
+	return v
+}
@@ -0,0 +1,536 @@
+// Package inkotools is a typed client for inkotools api
+package inkotools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout - default deadline for cheap requests
+const DefaultTimeout = 10 * time.Second
+
+// DefaultSlowTimeout - default deadline for slow requests, e.g. cable diagnostics
+const DefaultSlowTimeout = 60 * time.Second
+
+// DefaultRetries - default number of retries for idempotent requests
+const DefaultRetries = 2
+
+// DefaultRetryBackoff - default base delay between retries
+const DefaultRetryBackoff = 300 * time.Millisecond
+
+// ErrEmptyEndpoint - request without endpoint
+var ErrEmptyEndpoint = errors.New("Empty endpoint")
+
+// ErrRequest - request was not sent or response was not received
+var ErrRequest = errors.New("API request failed")
+
+// ErrTimeout - request deadline exceeded
+var ErrTimeout = errors.New("API request timed out")
+
+// ErrDecode - response body is not valid json or has unexpected shape
+var ErrDecode = errors.New("API response decode failed")
+
+// ErrStatus - api returned error status code
+var ErrStatus = errors.New("API returned error status")
+
+// ErrCircuitOpen - request was not sent because api is considered down
+var ErrCircuitOpen = errors.New("inkotools API is down")
+
+// Error - structured api error
+type Error struct {
+	Kind       error     // one of ErrEmptyEndpoint, ErrRequest, ErrTimeout, ErrDecode, ErrStatus, ErrCircuitOpen
+	Method     string    // http method
+	Endpoint   string    // api endpoint
+	StatusCode int       // http status code, zero if no response received
+	Detail     string    // error detail returned by api
+	Err        error     // underlying error, if any
+	Since      time.Time // time when api went down, for ErrCircuitOpen
+}
+
+// Error returns short message which is safe to show to user
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	if e.Kind == ErrStatus {
+		return strconv.Itoa(e.StatusCode)
+	}
+	return e.Kind.Error()
+}
+
+// Unwrap returns underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Logger - interface for client logging, kv are key/value pairs of structured fields
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Warning(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// nopLogger - default logger, discards everything
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{})   {}
+func (nopLogger) Warning(string, ...interface{}) {}
+func (nopLogger) Error(string, ...interface{})   {}
+
+// Client - inkotools api client
+type Client struct {
+	BaseURL     string                   // api url, e.g. http://127.0.0.1:9999/
+	HTTPClient  *http.Client             // http client used for requests
+	Log         Logger                   // logger for requests and errors
+	Timeout     time.Duration            // deadline for cheap requests, zero means no deadline
+	SlowTimeout time.Duration            // deadline for slow requests, zero means no deadline
+	Retries     int                      // number of retries for GET requests
+	Backoff     time.Duration            // base delay between retries, doubled for each next one
+	Breaker     *Breaker                 // circuit breaker, nil to disable
+	Cache       *Cache                   // response cache, nil to disable
+	CacheTTL    map[string]time.Duration // cache ttl for endpoint classes
+	// called after each request attempt, code is zero if there is no response
+	OnResponse func(method string, endpoint string, code int, latency time.Duration)
+}
+
+// NewClient - create new client for api url
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:     baseURL,
+		HTTPClient:  &http.Client{},
+		Log:         nopLogger{},
+		Timeout:     DefaultTimeout,
+		SlowTimeout: DefaultSlowTimeout,
+		Retries:     DefaultRetries,
+		Backoff:     DefaultRetryBackoff,
+		Breaker:     NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		Cache:       NewCache(),
+		CacheTTL:    DefaultCacheTTL,
+	}
+}
+
+// response envelope
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Detail json.RawMessage `json:"detail"`
+}
+
+// Ping - check that api is reachable, any http response is fine
+func (c *Client) Ping(ctx context.Context) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL, nil)
+	if err != nil {
+		return &Error{Kind: ErrRequest, Method: http.MethodGet, Err: err}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return &Error{Kind: requestErrorKind(ctx, err), Method: http.MethodGet, Err: err}
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// call response hook
+func (c *Client) observe(method string, endpoint string, code int, latency time.Duration) {
+	if c.OnResponse != nil {
+		c.OnResponse(method, endpoint, code, latency)
+	}
+}
+
+// universal api request with retries, raw response body is decoded to out (if not nil)
+func (c *Client) request(ctx context.Context, timeout time.Duration, method string, endpoint string, args interface{}, out interface{}) error {
+	attempts := 1
+	// only idempotent requests are retried
+	if method == http.MethodGet {
+		attempts += c.Retries
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			delay := c.backoff(i)
+			c.Log.Warning("[API] Retry", "method", method, "endpoint", endpoint, "retry", i, "retries", c.Retries, "delay", delay, "error", err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		}
+		if c.Breaker != nil {
+			if bErr := c.Breaker.Allow(); bErr != nil {
+				bErr.Method, bErr.Endpoint = method, endpoint
+				return bErr
+			}
+		}
+		err = c.attempt(ctx, timeout, method, endpoint, args, out)
+		if c.Breaker != nil {
+			switch {
+			case ctx.Err() != nil:
+				// caller gave up, result says nothing about api, trial is given to next request
+				c.Breaker.Release()
+			case isServiceFailure(err) || errors.Is(err, ErrTimeout):
+				// hung api is down too, but timed out requests are not retried
+				c.Breaker.Failure()
+			default:
+				c.Breaker.Success()
+			}
+		}
+		if !isServiceFailure(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// jittered exponential delay before retry number n (from 1)
+func (c *Client) backoff(n int) time.Duration {
+	d := c.Backoff << (n - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// check if error means that api itself is unavailable
+func isServiceFailure(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch {
+	case apiErr.Kind == ErrRequest && apiErr.Err != nil && !errors.Is(apiErr.Err, context.Canceled):
+		return true
+	case apiErr.Kind == ErrStatus:
+		switch apiErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// single api request, raw response body is decoded to out (if not nil)
+func (c *Client) attempt(ctx context.Context, timeout time.Duration, method string, endpoint string, args interface{}, out interface{}) error {
+	c.Log.Debug("[API] Request", "method", method, "endpoint", endpoint, "args", fmt.Sprintf("%+v", args), "timeout", timeout)
+	apiErr := &Error{Method: method, Endpoint: endpoint}
+	if endpoint == "" {
+		apiErr.Kind = ErrEmptyEndpoint
+		return apiErr
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// pack arguments to body
+	var reqBody io.Reader
+	if args != nil {
+		reqData, err := json.Marshal(args)
+		if err != nil {
+			c.Log.Error("[API] Pack args to json failed", "method", method, "endpoint", endpoint, "args", fmt.Sprintf("%+v", args), "error", err)
+			apiErr.Kind, apiErr.Err = ErrRequest, err
+			return apiErr
+		}
+		reqBody = bytes.NewBuffer(reqData)
+	}
+	// ensure that there is no double // symbols in url
+	url := strings.TrimRight(c.BaseURL, "/") + "/" + strings.TrimLeft(endpoint, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		c.Log.Error("[API] Creating request object failed", "method", method, "url", url, "error", err)
+		apiErr.Kind, apiErr.Err = ErrRequest, err
+		return apiErr
+	}
+	req.Header.Add("Content-Type", "application/json")
+	// send json request to api
+	start := time.Now()
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.Log.Error("[API] Request failed", "method", method, "endpoint", endpoint, "latency", time.Since(start), "error", err)
+		c.observe(method, endpoint, 0, time.Since(start))
+		apiErr.Kind, apiErr.Err = requestErrorKind(ctx, err), err
+		return apiErr
+	}
+	defer resp.Body.Close()
+	apiErr.StatusCode = resp.StatusCode
+	body, err := io.ReadAll(resp.Body)
+	c.observe(method, endpoint, resp.StatusCode, time.Since(start))
+	if err != nil {
+		c.Log.Error("[API] Read response failed", "method", method, "endpoint", endpoint, "error", err)
+		apiErr.Kind, apiErr.Err = requestErrorKind(ctx, err), err
+		return apiErr
+	}
+	// if we have no errors from api - decode result
+	if resp.StatusCode < 400 {
+		c.Log.Debug("[API] Response", "method", method, "endpoint", endpoint, "status", resp.StatusCode,
+			"latency", time.Since(start), "body", string(body))
+		if out == nil {
+			return nil
+		}
+		if err = json.Unmarshal(body, out); err != nil {
+			c.Log.Error("[API] Response json decode failed", "method", method, "endpoint", endpoint, "error", err)
+			apiErr.Kind, apiErr.Err = ErrDecode, err
+			return apiErr
+		}
+		return nil
+	}
+	// parse errors from api
+	apiErr.Kind = ErrStatus
+	var res response
+	if err = json.Unmarshal(body, &res); err == nil && len(res.Detail) > 0 {
+		// detail is string for handled errors and list for validation errors
+		var detail string
+		if json.Unmarshal(res.Detail, &detail) == nil {
+			apiErr.Detail = detail
+		}
+		c.Log.Warning("[API] Returned error", "method", method, "endpoint", endpoint, "status", resp.StatusCode,
+			"latency", time.Since(start), "detail", string(res.Detail))
+		return apiErr
+	}
+	c.Log.Error("[API] Returned error", "method", method, "endpoint", endpoint, "status", resp.StatusCode,
+		"latency", time.Since(start), "body", string(body))
+	return apiErr
+}
+
+// distinguish timeouts from other transport errors
+func requestErrorKind(ctx context.Context, err error) error {
+	var netErr interface{ Timeout() bool }
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return ErrRequest
+}
+
+// request and decode data field of response envelope to out
+func (c *Client) requestData(ctx context.Context, timeout time.Duration, method string, endpoint string, args interface{}, out interface{}) error {
+	var res response
+	if err := c.request(ctx, timeout, method, endpoint, args, &res); err != nil {
+		return err
+	}
+	return c.decodeData(method, endpoint, res.Data, out)
+}
+
+// decode data field of response envelope to out
+func (c *Client) decodeData(method string, endpoint string, data json.RawMessage, out interface{}) error {
+	if err := json.Unmarshal(data, out); err != nil {
+		c.Log.Error("[API] Data decode failed", "method", method, "endpoint", endpoint, "data", string(data), "error", err)
+		return &Error{Kind: ErrDecode, Method: method, Endpoint: endpoint, StatusCode: http.StatusOK, Err: err}
+	}
+	return nil
+}
+
+// get request shortcut for cheap requests
+func (c *Client) get(ctx context.Context, endpoint string, out interface{}) error {
+	return c.requestData(ctx, c.Timeout, http.MethodGet, endpoint, nil, out)
+}
+
+// get request shortcut for cacheable requests, class defines cache ttl
+func (c *Client) getCached(ctx context.Context, class string, endpoint string, out interface{}) error {
+	ttl := c.CacheTTL[class]
+	if c.Cache == nil || ttl <= 0 {
+		return c.get(ctx, endpoint, out)
+	}
+	if !noCache(ctx) {
+		if data, ok := c.Cache.get(class, endpoint); ok {
+			c.Log.Debug("[API] Cache hit", "method", http.MethodGet, "endpoint", endpoint)
+			return c.decodeData(http.MethodGet, endpoint, data, out)
+		}
+	}
+	var data json.RawMessage
+	if err := c.get(ctx, endpoint, &data); err != nil {
+		return err
+	}
+	if err := c.decodeData(http.MethodGet, endpoint, data, out); err != nil {
+		return err
+	}
+	c.Cache.set(endpoint, data, ttl)
+	return nil
+}
+
+// get request shortcut for slow requests
+func (c *Client) getSlow(ctx context.Context, endpoint string, out interface{}) error {
+	return c.requestData(ctx, c.SlowTimeout, http.MethodGet, endpoint, nil, out)
+}
+
+// GetSwitch - get switch summary
+func (c *Client) GetSwitch(ctx context.Context, ip string) (Switch, error) {
+	var sw Switch
+	endpoint := fmt.Sprintf("/sw/%s/", ip)
+	err := c.getCached(ctx, "switch", endpoint, &sw)
+	// switch class is cached only if enabled in config, unavailable switch is checked again on next request
+	if err == nil && !sw.Status && c.Cache != nil {
+		c.Cache.delete(endpoint)
+	}
+	return sw, err
+}
+
+// GetFreePorts - get list of free switch ports
+func (c *Client) GetFreePorts(ctx context.Context, ip string) ([]Port, error) {
+	var ports []Port
+	err := c.getSlow(ctx, fmt.Sprintf("/sw/%s/freeports/", ip), &ports)
+	return ports, err
+}
+
+// GetAccessPorts - get list of switch access ports with state
+func (c *Client) GetAccessPorts(ctx context.Context, ip string) ([]Port, error) {
+	var ports []Port
+	err := c.getSlow(ctx, fmt.Sprintf("/sw/%s/accessports/", ip), &ports)
+	return ports, err
+}
+
+// GetAccessPortNumbers - get numbers of switch access ports
+func (c *Client) GetAccessPortNumbers(ctx context.Context, ip string) ([]int, error) {
+	var data struct {
+		AccessPorts []int `json:"access_ports"`
+	}
+	err := c.getCached(ctx, "access_ports", fmt.Sprintf("/sw/%s/ports/", ip), &data)
+	return data.AccessPorts, err
+}
+
+// GetSwitchLogs - get switch log events
+func (c *Client) GetSwitchLogs(ctx context.Context, ip string, offset int, limit int) ([]LogEvent, error) {
+	var events []LogEvent
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/log?offset=%d&limit=%d", ip, offset, limit), &events)
+	return events, err
+}
+
+// GetPortLogs - get port log events
+func (c *Client) GetPortLogs(ctx context.Context, ip string, port string, offset int, limit int) ([]LogEvent, error) {
+	var events []LogEvent
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/log?offset=%d&limit=%d", ip, port, offset, limit), &events)
+	return events, err
+}
+
+// GetPortSlots - get port info, combo ports have more than one slot
+func (c *Client) GetPortSlots(ctx context.Context, ip string, port string) ([]Port, error) {
+	var slots []Port
+	err := c.getSlow(ctx, fmt.Sprintf("/sw/%s/ports/%s/", ip, port), &slots)
+	if err == nil && len(slots) == 0 {
+		err = &Error{Kind: ErrDecode, Method: http.MethodGet, Endpoint: fmt.Sprintf("/sw/%s/ports/%s/", ip, port),
+			StatusCode: http.StatusOK, Err: errors.New("empty slots list")}
+	}
+	return slots, err
+}
+
+// GetPortLinkDownCount - get port link down count for last 24h
+func (c *Client) GetPortLinkDownCount(ctx context.Context, ip string, port string) (int, error) {
+	var count int
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/linkdowncount", ip, port), &count)
+	return count, err
+}
+
+// GetPortCounters - get port traffic and error counters
+func (c *Client) GetPortCounters(ctx context.Context, ip string, port string) (PortCounters, error) {
+	var counters PortCounters
+	err := c.getSlow(ctx, fmt.Sprintf("/sw/%s/ports/%s/counters", ip, port), &counters)
+	return counters, err
+}
+
+// ClearPortCounters - clear port counters, returns api detail message
+func (c *Client) ClearPortCounters(ctx context.Context, ip string, port string) (string, error) {
+	endpoint := fmt.Sprintf("/sw/%s/ports/%s/counters", ip, port)
+	var res response
+	if err := c.request(ctx, c.Timeout, http.MethodDelete, endpoint, nil, &res); err != nil {
+		return "", err
+	}
+	var detail string
+	if err := json.Unmarshal(res.Detail, &detail); err != nil || detail == "" {
+		c.Log.Error("[API] No detail in response", "method", http.MethodDelete, "endpoint", endpoint, "detail", string(res.Detail))
+		return "", &Error{Kind: ErrDecode, Method: http.MethodDelete, Endpoint: endpoint,
+			StatusCode: http.StatusOK, Detail: "Empty response", Err: err}
+	}
+	return detail, nil
+}
+
+// GetPortMAC - get port mac address table
+func (c *Client) GetPortMAC(ctx context.Context, ip string, port string) ([]PortMac, error) {
+	var entries []PortMac
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/mac", ip, port), &entries)
+	return entries, err
+}
+
+// GetPortBandwidth - get port bandwidth limits
+func (c *Client) GetPortBandwidth(ctx context.Context, ip string, port string) (PortBandwidth, error) {
+	var bw PortBandwidth
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/bandwidth", ip, port), &bw)
+	return bw, err
+}
+
+// GetPortVlan - get port vlan membership
+func (c *Client) GetPortVlan(ctx context.Context, ip string, port string) (PortVlan, error) {
+	var vlan PortVlan
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/vlan", ip, port), &vlan)
+	return vlan, err
+}
+
+// GetPortACL - get port acl entries
+func (c *Client) GetPortACL(ctx context.Context, ip string, port string) ([]PortACL, error) {
+	var entries []PortACL
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/acl", ip, port), &entries)
+	return entries, err
+}
+
+// GetMulticast - get switch multicast vlan source and member ports
+func (c *Client) GetMulticast(ctx context.Context, ip string) (PortMulticast, error) {
+	var mcast PortMulticast
+	err := c.getCached(ctx, "multicast", fmt.Sprintf("/sw/%s/multicast", ip), &mcast)
+	return mcast, err
+}
+
+// GetPortMcastFilters - get port multicast filters
+func (c *Client) GetPortMcastFilters(ctx context.Context, ip string, port string) ([]string, error) {
+	var filters []string
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/mcast/filters", ip, port), &filters)
+	return filters, err
+}
+
+// GetPortMcastGroups - get port multicast groups
+func (c *Client) GetPortMcastGroups(ctx context.Context, ip string, port string) ([]string, error) {
+	var groups []string
+	err := c.get(ctx, fmt.Sprintf("/sw/%s/ports/%s/mcast/groups", ip, port), &groups)
+	return groups, err
+}
+
+// ArpSearch - search arp entries by ip or mac
+func (c *Client) ArpSearch(ctx context.Context, q ARPQuery) ([]ARPEntry, error) {
+	var entries []ARPEntry
+	err := c.requestData(ctx, c.Timeout, http.MethodPost, "/arpsearch", q, &entries)
+	return entries, err
+}
+
+// DBSearch - search switches in database by keyword
+func (c *Client) DBSearch(ctx context.Context, keyword string, page int, perPage int) (DBSearch, error) {
+	var result DBSearch
+	args := map[string]interface{}{"keyword": keyword, "page": page, "per_page": perPage}
+	err := c.request(ctx, c.Timeout, http.MethodPost, "/db/search", args, &result)
+	return result, err
+}
+
+// IPCalc - get ip address summary
+func (c *Client) IPCalc(ctx context.Context, ip string) (IPCalc, error) {
+	var calc IPCalc
+	err := c.getCached(ctx, "ipcalc", fmt.Sprintf("/ipcalc/%s/", ip), &calc)
+	return calc, err
+}
+
+// ClearPool - clear api switches connection pool
+func (c *Client) ClearPool(ctx context.Context) error {
+	return c.request(ctx, c.Timeout, http.MethodDelete, "/pool", nil, nil)
+}
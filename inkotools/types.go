@@ -0,0 +1,142 @@
+package inkotools
+
+import "time"
+
+// Switch type
+type Switch struct {
+	IP       string `json:"ip"`
+	Location string `json:"location"`
+	MAC      string `json:"mac"`
+	Model    string `json:"model"`
+	Status   bool   `json:"status"`
+}
+
+// Port type
+type Port struct {
+	Port          int    `json:"port"`
+	Type          string `json:"type"`
+	State         bool   `json:"state"`
+	Speed         string `json:"speed"`
+	Link          bool   `json:"link"`
+	Status        string `json:"status"`
+	Learning      bool   `json:"learning"`
+	Autodowngrade bool   `json:"autodowngrade"`
+	Description   string `json:"desc"`
+	Cable         []Pair `json:"cable"`
+	DDM           struct {
+		Temperature float32 `json:"temperature"`
+		Voltage     float32 `json:"voltage"`
+		BiasCurrent float32 `json:"bias_current"`
+		PowerTX     float32 `json:"tx_power"`
+		PowerRX     float32 `json:"rx_power"`
+	} `json:"ddm"`
+}
+
+// Pair type - pair in cable
+type Pair struct {
+	Pair  int    `json:"pair"`
+	State string `json:"state"`
+	Len   int    `json:"len"`
+}
+
+// PortBandwidth limits type
+type PortBandwidth struct {
+	RX uint `json:"rx"`
+	TX uint `json:"tx"`
+}
+
+// PortCounters type
+type PortCounters struct {
+	TotalRX  uint        `json:"rx_total"`
+	TotalTX  uint        `json:"tx_total"`
+	SpeedRX  uint        `json:"rx_speed"`
+	SpeedTX  uint        `json:"tx_speed"`
+	ErrorsRX []PortError `json:"rx_errors"`
+	ErrorsTX []PortError `json:"tx_errors"`
+}
+
+// PortError type
+type PortError struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// PortVlan type
+type PortVlan struct {
+	Port     int   `json:"port"`
+	Untagged []int `json:"untagged"`
+	Tagged   []int `json:"tagged"`
+}
+
+// PortMac type
+type PortMac struct {
+	Port   int    `json:"port"`
+	VlanID int    `json:"vid"`
+	Mac    string `json:"mac"`
+}
+
+// PortACL type
+type PortACL struct {
+	Port      int    `json:"port"`
+	ProfileID int    `json:"profile_id"`
+	AccessID  int    `json:"access_id"`
+	IP        string `json:"ip"`
+	Mask      string `json:"mask"`
+	Mode      string `json:"mode"`
+}
+
+// PortMulticast type
+type PortMulticast struct {
+	SourcePorts []int `json:"source"`
+	MemberPorts []int `json:"member"`
+	// port values, filled from other endpoints
+	State   bool     `json:"state,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Filters []string `json:"filters,omitempty"`
+}
+
+// IPCalc type
+type IPCalc struct {
+	IP      string `json:"ip"`
+	Mask    string `json:"mask"`
+	Gateway string `json:"gateway"`
+	Prefix  int    `json:"prefix"`
+}
+
+// ARPEntry type
+type ARPEntry struct {
+	IP     string `json:"ip"`
+	Mac    string `json:"mac"`
+	VlanID int    `json:"vid"`
+	State  bool   `json:"state"`
+}
+
+// ARPQuery type - arp search parameters, empty fields are omitted
+type ARPQuery struct {
+	IP      string `json:"ip,omitempty"`
+	Mac     string `json:"mac,omitempty"`
+	SrcSwIP string `json:"src_sw_ip,omitempty"`
+}
+
+// DBSearch type
+type DBSearch struct {
+	Data []Switch `json:"data"`
+	Meta struct {
+		Entries struct {
+			Current int `json:"current"`
+			PerPage int `json:"per_page"`
+			Total   int `json:"total"`
+		} `json:"entries"`
+		Pages struct {
+			Current int `json:"current"`
+			Total   int `json:"total"`
+		} `json:"pages"`
+	} `json:"meta"`
+}
+
+// LogEvent type
+type LogEvent struct {
+	Time     time.Time `json:"timestamp"`
+	LogLevel string    `json:"log_level"`
+	Message  string    `json:"message"`
+}
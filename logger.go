@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level - log level
+type Level int
+
+// log levels, messages below logger level are discarded
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+// level names in config and output
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+// String - level name
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// parse level name
+func parseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+// Logger - leveled structured logger, writes one line per message in logfmt or json
+type Logger struct {
+	mu        sync.Mutex
+	out       io.Writer
+	json      bool             // json output, logfmt otherwise
+	level     Level            // default level
+	overrides map[string]Level // levels for subsystems, key is subsystem name
+}
+
+// Log - bot logger, works with defaults until config is loaded
+var Log = NewLogger(os.Stderr)
+
+// NewLogger - create logfmt logger with info level
+func NewLogger(out io.Writer) *Logger {
+	return &Logger{out: out, level: LevelInfo, overrides: make(map[string]Level)}
+}
+
+// Configure - set output format, default level and subsystem levels
+func (l *Logger) Configure(format string, level Level, overrides map[string]Level) error {
+	if format != "" && format != "logfmt" && format != "json" {
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.json = format == "json"
+	l.level = level
+	l.overrides = make(map[string]Level)
+	for sub, lvl := range overrides {
+		l.overrides[strings.ToLower(sub)] = lvl
+	}
+	return nil
+}
+
+// SetLevel - set level for subsystem, empty subsystem is default level
+func (l *Logger) SetLevel(sub string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sub == "" {
+		l.level = level
+	} else {
+		l.overrides[strings.ToLower(sub)] = level
+	}
+}
+
+// ResetLevel - remove subsystem level override
+func (l *Logger) ResetLevel(sub string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.overrides, strings.ToLower(sub))
+}
+
+// Levels - default level and subsystem levels
+func (l *Logger) Levels() (Level, map[string]Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	overrides := make(map[string]Level, len(l.overrides))
+	for sub, lvl := range l.overrides {
+		overrides[sub] = lvl
+	}
+	return l.level, overrides
+}
+
+// get subsystem from message tag, e.g. [API] is api
+func subsystem(msg string) string {
+	if !strings.HasPrefix(msg, "[") {
+		return ""
+	}
+	i := strings.Index(msg, "]")
+	if i < 0 {
+		return ""
+	}
+	tag, _ := splitArgs(msg[1:i])
+	return strings.ToLower(tag)
+}
+
+// Print - write message with key/value fields if level is enabled for message subsystem
+func (l *Logger) Print(level Level, msg string, kv ...interface{}) {
+	sub := subsystem(msg)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	min, ok := l.overrides[sub]
+	if !ok {
+		min = l.level
+	}
+	if level < min {
+		return
+	}
+	fields := []interface{}{"time", time.Now().Format(time.RFC3339), "level", level.String()}
+	if sub != "" {
+		fields = append(fields, "sub", sub)
+	}
+	fields = append(fields, "msg", msg)
+	fields = append(fields, kv...)
+	// odd number of fields is a caller mistake, keep value anyway
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+	var buf bytes.Buffer
+	if l.json {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+	l.out.Write(buf.Bytes())
+}
+
+// format field value as string
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// write fields as logfmt line
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fieldString(fields[i]))
+		buf.WriteByte('=')
+		v := fieldString(fields[i+1])
+		if v == "" || strings.ContainsAny(v, " =\"\n\t") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+}
+
+// write fields as json object keeping fields order
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(fieldString(fields[i]))
+		buf.Write(k)
+		buf.WriteByte(':')
+		var v interface{} = fields[i+1]
+		switch fv := v.(type) {
+		case error, time.Duration, fmt.Stringer:
+			v = fieldString(fv)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fieldString(fields[i+1]))
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+}
+
+// apply log settings from config
+func initLogger(cfg Config) error {
+	level := LevelInfo
+	if cfg.DebugMode {
+		level = LevelDebug
+	}
+	var err error
+	if cfg.LogLevel != "" {
+		if level, err = parseLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	overrides := make(map[string]Level)
+	for sub, name := range cfg.LogLevels {
+		if overrides[sub], err = parseLevel(name); err != nil {
+			return err
+		}
+	}
+	return Log.Configure(cfg.LogFormat, level, overrides)
+}
+
+// log level command handler: [SUBSYSTEM] [LEVEL|reset]
+func logLevelHandler(ctx context.Context, args string) string {
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 0:
+		// show levels
+	case 1:
+		level, err := parseLevel(fields[0])
+		if err != nil {
+			return fmtErr(err.Error())
+		}
+		Log.SetLevel("", level)
+	case 2:
+		if fields[1] == "reset" {
+			Log.ResetLevel(fields[0])
+			break
+		}
+		level, err := parseLevel(fields[1])
+		if err != nil {
+			return fmtErr(err.Error())
+		}
+		Log.SetLevel(fields[0], level)
+	default:
+		return fmtErr(tr(ctx, "Wrong arguments"))
+	}
+	level, overrides := Log.Levels()
+	res := trf(ctx, "Log level: <code>%s</code>\n", level)
+	subs := make([]string, 0, len(overrides))
+	for sub := range overrides {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	for _, sub := range subs {
+		res += fmt.Sprintf("<code>%s</code>: <code>%s</code>\n", sub, overrides[sub])
+	}
+	return res
+}
+
+// uid and user name fields for logs
+func userFields(uid int64) []interface{} {
+	return []interface{}{"uid", uid, "user", userName(uid)}
+}
+
+// debug log
+func logDebug(msg string, kv ...interface{}) {
+	Log.Print(LevelDebug, msg, kv...)
+}
+
+// info log
+func logInfo(msg string, kv ...interface{}) {
+	Log.Print(LevelInfo, msg, kv...)
+}
+
+// warning log
+func logWarning(msg string, kv ...interface{}) {
+	Log.Print(LevelWarning, msg, kv...)
+}
+
+// error log
+func logError(msg string, kv ...interface{}) {
+	Log.Print(LevelError, msg, kv...)
+}
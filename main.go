@@ -2,26 +2,31 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"html"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/go-ping/ping"
-	"github.com/mitchellh/mapstructure"
 	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"inkotools-bot/inkotools"
 )
 
 // CFGFILE - path to config file
@@ -29,275 +34,253 @@ const CFGFILE string = "config/main.yml"
 
 // Config struct
 type Config struct {
-	BotToken        string `yaml:"bot_token"`
-	UseWebhook      bool   `yaml:"use_webhook"`
-	WebhookURL      string `yaml:"webhook_url"`
-	ListenPort      string `yaml:"listen_port"`
-	Admin           int64  `yaml:"admin"`
-	InkoToolsAPI    string `yaml:"inkotools_api_url"`
-	DebugMode       bool   `yaml:"debug"`
-	MaintenanceMode bool   `yaml:"maintenance"`
-	MaintenanceMsg  string `yaml:"maintenance_message"`
+	BotToken              string                   `yaml:"bot_token"`
+	UseWebhook            bool                     `yaml:"use_webhook"`
+	WebhookURL            string                   `yaml:"webhook_url"`
+	ListenPort            string                   `yaml:"listen_port"`
+	HealthPort            string                   `yaml:"health_port"`
+	HealthStuckAfter      time.Duration            `yaml:"health_stuck_after"`
+	ShutdownTimeout       time.Duration            `yaml:"shutdown_timeout"`
+	Admin                 int64                    `yaml:"admin"`
+	Admins                []int64                  `yaml:"admins"`
+	AdminGroup            int64                    `yaml:"admin_group"`
+	AccessRequestInterval time.Duration            `yaml:"access_request_interval"`
+	InkoToolsAPI          string                   `yaml:"inkotools_api_url"`
+	SwitchSubnets         []string                 `yaml:"switch_subnets"`
+	ClientSubnets         []string                 `yaml:"client_subnets"`
+	IPShortcuts           []IPShortcut             `yaml:"ip_shortcuts"`
+	APITimeout            time.Duration            `yaml:"api_timeout"`
+	APISlowTimeout        time.Duration            `yaml:"api_slow_timeout"`
+	APIRetries            int                      `yaml:"api_retries"`
+	APIDownAfter          int                      `yaml:"api_down_after"`
+	APIDownCooldown       time.Duration            `yaml:"api_down_cooldown"`
+	APICache              map[string]time.Duration `yaml:"api_cache"`
+	SummaryWorkers        int                      `yaml:"summary_workers"`
+	SummaryTimeout        time.Duration            `yaml:"summary_timeout"`
+	Store                 string                   `yaml:"store"`
+	DataDir               string                   `yaml:"data_dir"`
+	AuditFile             string                   `yaml:"audit_file"`
+	AuditMaxSize          int                      `yaml:"audit_max_size"`
+	AuditBackups          int                      `yaml:"audit_backups"`
+	DebugMode             bool                     `yaml:"debug"`
+	LogFormat             string                   `yaml:"log_format"`
+	LogLevel              string                   `yaml:"log_level"`
+	LogLevels             map[string]string        `yaml:"log_levels"`
+	MaintenanceMode       bool                     `yaml:"maintenance"`
+	MaintenanceMsg        string                   `yaml:"maintenance_message"`
+	Timezone              string                   `yaml:"timezone"`
+	Language              string                   `yaml:"language"`
+}
+
+// DefaultSummaryWorkers - default number of parallel api requests for port summary
+const DefaultSummaryWorkers int = 4
+
+// DefaultSummaryTimeout - default total deadline for port summary
+const DefaultSummaryTimeout time.Duration = 90 * time.Second
+
+// list of admin uids, including single admin from legacy option
+func (c Config) adminIDs() []int64 {
+	var ids []int64
+	for _, id := range append([]int64{c.Admin}, c.Admins...) {
+		if id != 0 && !int64InList(id, ids) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 // UserConfig struct
 type UserConfig struct {
-	Name string `yaml:"name"`
+	Name     string `yaml:"name"`
+	Role     string `yaml:"role,omitempty"`     // viewer, engineer or admin, empty is viewer
+	Timezone string `yaml:"timezone,omitempty"` // overrides timezone from main config
+	Language string `yaml:"language,omitempty"` // overrides language from telegram profile
 }
 
 // UserData struct
 type UserData struct {
-	Mode string // command mode
-	TMP  string // to save temporary data between messages
+	Mode       string   // command mode
+	TMP        string   // to save temporary data between messages
+	LastSwitch string   // last viewed switch ip
+	History    []string // last raw requests, newest first
+	PortView   string   // preferred port summary view, short or full
+	Lang       string   // language code from telegram profile
 }
 
+// HistorySize - max number of requests in user history
+const HistorySize int = 10
+
+// number of log events on page
+const logPageSize int = 5
+
 // Cron - cron object
 var Cron *cron.Cron
 
 // Data - data object
 var Data map[int64]*UserData
 
+// DataMu - guards Data map
+var DataMu sync.Mutex
+
 // CFG - config object
 var CFG Config
 
+// maintenance mode toggled by admin at runtime, nil if not toggled, guarded by CFGMu
+var maintenanceOverride *bool
+
+// maintenance mode from config file at last load, guarded by CFGMu
+var maintenanceFile bool
+
+// CFGMu - guards CFG, TPL and API against reload
+var CFGMu sync.RWMutex
+
 // Users - users config
 var Users map[int64]*UserConfig
 
+// UsersMu - guards Users map
+var UsersMu sync.RWMutex
+
 // TPL - templates object
 var TPL *template.Template
 
 // Bot - bot object
 var Bot *tgbotapi.BotAPI
 
+// Sender - telegram api methods used to send, edit and delete messages
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// Telegram - sender for bot messages, Bot in production
+var Telegram Sender
+
 // Pingers - map of active pingers, key is uid
-var Pingers map[int64]ping.Pinger
+var Pingers map[int64]*ping.Pinger
 
-// Switch type
-type Switch struct {
-	IP       string `mapstructure:"ip"`
-	Location string `mapstructure:"location"`
-	MAC      string `mapstructure:"mac"`
-	Model    string `mapstructure:"model"`
-	Status   bool   `mapstructure:"status"`
-}
+// PingersMu - guards Pingers map
+var PingersMu sync.Mutex
+
+// PingersWG - running pingers, including final statistics sending
+var PingersWG sync.WaitGroup
+
+// API - inkotools api client
+var API *inkotools.Client
 
 // PortSummary type
 type PortSummary struct {
-	Style      string
-	Slots      []Port
-	LinkUp     bool
-	PortNumber int
-	Bandwidth  PortBandwidth
+	Style      string                  `json:"style"`
+	Slots      []inkotools.Port        `json:"slots"`
+	LinkUp     bool                    `json:"link_up"`
+	PortNumber int                     `json:"port"`
+	Bandwidth  inkotools.PortBandwidth `json:"bandwidth"`
 	Counters   struct {
-		PortCounters `mapstructure:",squash"`
-		Error        string
-	}
+		inkotools.PortCounters
+		Error string `json:"error,omitempty"`
+	} `json:"counters"`
 	VLAN struct {
-		PortVlan `mapstructure:",squash"`
-		Error    string
-	}
+		inkotools.PortVlan
+		Error string `json:"error,omitempty"`
+	} `json:"vlan"`
 	ACL struct {
-		Entries []PortACL
-		Error   string
-	}
+		Entries []inkotools.PortACL `json:"entries"`
+		Error   string              `json:"error,omitempty"`
+	} `json:"acl"`
 	Multicast struct {
-		PortMulticast `mapstructure:",squash"`
-		Error         string
-	}
+		inkotools.PortMulticast
+		Error string `json:"error,omitempty"`
+	} `json:"multicast"`
 	MAC struct {
-		Entries []PortMac
-		Error   string
-	}
+		Entries []inkotools.PortMac `json:"entries"`
+		Error   string              `json:"error,omitempty"`
+	} `json:"mac"`
 	ARP struct {
-		Entries []ARPEntry
-		Error   string
-	}
-	LinkDownCount int
-	LastLogEvent  string
-}
-
-// Port type
-type Port struct {
-	Port          int    `mapstructure:"port"`
-	Type          string `mapstructure:"type"`
-	State         bool   `mapstructure:"state"`
-	Speed         string `mapstructure:"speed"`
-	Link          bool   `mapstructure:"link"`
-	Status        string `mapstructure:"status"`
-	Learning      bool   `mapstructure:"learning"`
-	Autodowngrade bool   `mapstructure:"autodowngrade"`
-	Description   string `mapstructure:"desc"`
-	Cable         []Pair `mapstructure:"cable"`
-	DDM           struct {
-		Temperature float32 `mapstructure:"temperature"`
-		Voltage     float32 `mapstructure:"voltage"`
-		BiasCurrent float32 `mapstructure:"bias_current"`
-		PowerTX     float32 `mapstructure:"tx_power"`
-		PowerRX     float32 `mapstructure:"rx_power"`
-	} `mapstructure:"ddm"`
-}
-
-// Pair type - pair in cable
-type Pair struct {
-	Pair  int    `mapstructure:"pair"`
-	State string `mapstructure:"state"`
-	Len   int    `mapstructure:"len"`
-}
-
-// PortBandwidth limits type
-type PortBandwidth struct {
-	RX uint `mapstructure:"rx"`
-	TX uint `mapstructure:"tx"`
-}
-
-// PortCounters type
-type PortCounters struct {
-	TotalRX  uint        `mapstructure:"rx_total"`
-	TotalTX  uint        `mapstructure:"tx_total"`
-	SpeedRX  uint        `mapstructure:"rx_speed"`
-	SpeedTX  uint        `mapstructure:"tx_speed"`
-	ErrorsRX []PortError `mapstructure:"rx_errors"`
-	ErrorsTX []PortError `mapstructure:"tx_errors"`
-}
-
-// PortError type
-type PortError struct {
-	Name  string `mapstructure:"name"`
-	Count int    `mapstructure:"count"`
-}
-
-// PortVlan type
-type PortVlan struct {
-	Port     int   `mapstructure:"port"`
-	Untagged []int `mapstructure:"untagged"`
-	Tagged   []int `mapstructure:"tagged"`
-}
-
-// PortMac type
-type PortMac struct {
-	Port   int    `mapstructure:"port"`
-	VlanID int    `mapstructure:"vid"`
-	Mac    string `mapstructure:"mac"`
-}
-
-// PortACL type
-type PortACL struct {
-	Port      int    `mapstructure:"port"`
-	ProfileID int    `mapstructure:"profile_id"`
-	AccessID  int    `mapstructure:"access_id"`
-	IP        string `mapstructure:"ip"`
-	Mask      string `mapstructure:"mask"`
-	Mode      string `mapstructure:"mode"`
-}
-
-// PortMulticast type
-type PortMulticast struct {
-	SourcePorts []int `mapstructure:"source"`
-	MemberPorts []int `mapstructure:"member"`
-	State       bool
-	Groups      []string
-	Filters     []string
-}
-
-// IPCalc type
-type IPCalc struct {
-	IP      string `mapstructure:"ip"`
-	Mask    string `mapstructure:"mask"`
-	Gateway string `mapstructure:"gateway"`
-	Prefix  int    `mapstructure:"prefix"`
-}
-
-// ARPEntry type
-type ARPEntry struct {
-	IP     string `mapstructure:"ip"`
-	Mac    string `mapstructure:"mac"`
-	VlanID int    `mapstructure:"vid"`
-	State  bool   `mapstructure:"state"`
-}
-
-// DBSearch type
-type DBSearch struct {
-	Data []Switch `mapstructure:"data"`
-	Meta struct {
-		Entries struct {
-			Current int `mapstructure:"current"`
-			PerPage int `mapstructure:"per_page"`
-			Total   int `mapstructure:"total"`
-		} `mapstructure:"entries"`
-		Pages struct {
-			Current int `mapstructure:"current"`
-			Total   int `mapstructure:"total"`
-		} `mapstructure:"pages"`
-	} `mapstructure:"meta"`
+		Entries []inkotools.ARPEntry `json:"entries"`
+		Error   string               `json:"error,omitempty"`
+	} `json:"arp"`
+	LinkDownCount int    `json:"link_down_count"`
+	LastLogEvent  string `json:"last_event"`
 }
 
-// LogEvent type
-type LogEvent struct {
-	Time     time.Time `mapstructure:"timestamp"`
-	LogLevel string    `mapstructure:"log_level"`
-	Message  string    `mapstructure:"message"`
-}
-
-// ColorReset - ANSI color
-const ColorReset string = "\033[0m"
-
-// ColorRed - ANSI color
-const ColorRed string = "\033[31m"
-
-// ColorGreen - ANSI color
-const ColorGreen string = "\033[32m"
-
-// ColorYellow - ANSI color
-const ColorYellow string = "\033[33m"
-
-// ColorBlue - ANSI color
-const ColorBlue string = "\033[34m"
-
-// ColorPurple - ANSI color
-const ColorPurple string = "\033[35m"
-
-// ColorCyan - ANSI color
-const ColorCyan string = "\033[36m"
-
-// ColorWhite - ANSI color
-const ColorWhite string = "\033[37m"
-
-// HELPUSER - help string for user
-const HELPUSER string = `
-<code>SW_IP</code> - get switch summary
-<code>SW_IP PORT</code> - get port info
-<code>SW_IP free</code> - get free ports
-<code>/ping IP</code> - ping
-<code>/calc IP</code> - ip calc
-
-`
-
 // HELPADMIN - help string for admin
 const HELPADMIN string = `
 <code>list</code> - list authorized users
 <code>add ID [NAME]</code> - add user with id <b><i>ID</i></b> and optional mark with comment <b><i>NAME</i></b>
 <code>del ID</code> - delete user with id <b><i>ID</i></b>
+<code>role ID ROLE</code> - set role <b><i>ROLE</i></b> (viewer, engineer or admin) for user with id <b><i>ID</i></b>
 <code>send ID TEXT</code> - send message <b><i>TEXT</i></b> to user with id <b><i>ID</i></b>
 <code>broadcast TEXT</code> - send broadcast message <b><i>TEXT</i></b> 
 <code>reload</code> - reload configuration from file
+<code>maintenance [on|off]</code> - show or toggle maintenance mode, toggle is kept until maintenance option in config file is changed
+<code>cache [flush]</code> - show api cache hit rates or flush cache
+<code>log [SUBSYSTEM] [LEVEL|reset]</code> - show or change log level, optionally only for <b><i>SUBSYSTEM</i></b> (api, ping, ...)
+<code>audit [IP] [user ID] [since 2d]</code> - last user actions, filtered by switch <b><i>IP</i></b>, user <b><i>ID</i></b> and period
 `
 
-// BotCommands const
-var BotCommands = []tgbotapi.BotCommand{
-	{
-		Command:     "help",
-		Description: "print help",
-	},
-}
+// MsgCannotDelete - close button error for old messages
+const MsgCannotDelete string = "<b>Bot cannot delete messages older than 48 hours!</b> \n\n" +
+	"<i>This is is a telegram api limitation. You can delete this message manually.</i> \n\n" +
+	"<code>https://core.telegram.org/bots/api#deletemessage</code>"
 
 // HELPER FUNCTIONS
 
 // check if uid is in users map
 func userIsAuthorized(id int64) bool {
+	UsersMu.RLock()
+	defer UsersMu.RUnlock()
 	_, ok := Users[id]
 	return ok
 }
 
+// get user name for logs and messages, uid for unknown users
+func userName(id int64) string {
+	UsersMu.RLock()
+	defer UsersMu.RUnlock()
+	if u, ok := Users[id]; ok {
+		return u.Name
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// get list of authorized uids
+func userIDs() []int64 {
+	UsersMu.RLock()
+	defer UsersMu.RUnlock()
+	ids := make([]int64, 0, len(Users))
+	for id := range Users {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// get user data, loads it from store if missing
+func getUserData(uid int64) *UserData {
+	DataMu.Lock()
+	d, ok := Data[uid]
+	DataMu.Unlock()
+	if ok {
+		return d
+	}
+	initUserData(uid)
+	DataMu.Lock()
+	defer DataMu.Unlock()
+	return Data[uid]
+}
+
+// get copy of current config
+func getConfig() Config {
+	CFGMu.RLock()
+	defer CFGMu.RUnlock()
+	return CFG
+}
+
+// get current api client
+func getAPI() *inkotools.Client {
+	CFGMu.RLock()
+	defer CFGMu.RUnlock()
+	return API
+}
+
 // search int in list of int
 func intInList(val int, lst []int) bool {
 	sort.Ints(lst)
@@ -305,6 +288,16 @@ func intInList(val int, lst []int) bool {
 	return !((idx == len(lst)) || (val != lst[idx]))
 }
 
+// search int64 in unsorted list of int64
+func int64InList(val int64, lst []int64) bool {
+	for _, v := range lst {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
 // split first arg from args
 func splitArgs(args string) (first string, other string) {
 	a := strings.SplitN(args, " ", 2)
@@ -320,30 +313,35 @@ func splitLast(args string) (before string, last string) {
 	return args[:i], args[i+1:]
 }
 
-// convert x.x --> 192.168.x.x, return empty string on invalid ip
-func fullIP(ip string, isSwitch bool) string {
-	octet := `[0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5]`
-	reFull, _ := regexp.Compile(`^((` + octet + `)\.){3}(` + octet + `)$`)
-	reShort, _ := regexp.Compile(`^(` + octet + `)\.(` + octet + `)$`)
-	reSwitch, _ := regexp.Compile(`^192\.168\.(4[79]|5[7-9]|60)\.(` + octet + `)$`)
-	if reShort.MatchString(ip) {
-		ip = "192.168." + ip
-	}
-	if isSwitch && reSwitch.MatchString(ip) || !isSwitch && reFull.MatchString(ip) {
-		return ip
-	}
-	return ""
-}
-
 // print error in message
 func fmtErr(e string) string {
 	return "\n<b>ERROR</b>&#8252;\n<code>" + e + "</code>\n"
 }
 
-// print object formatted with template
-func fmtObj(obj interface{}, tpl string) string {
+// error text for user, time of api outage is printed in user timezone
+func errText(ctx context.Context, err error) string {
+	var apiErr *inkotools.Error
+	if errors.As(err, &apiErr) && apiErr.Kind == inkotools.ErrCircuitOpen && !apiErr.Since.IsZero() {
+		return trf(ctx, "inkotools API is down since %s", localTime(ctx, apiErr.Since).Format("15:04"))
+	}
+	return err.Error()
+}
+
+// print object formatted with template, user from context is used for timezone
+func fmtObj(ctx context.Context, obj interface{}, tpl string) string {
 	var buf bytes.Buffer
-	TPL.ExecuteTemplate(&buf, tpl, obj)
+	CFGMu.RLock()
+	t := TPL
+	CFGMu.RUnlock()
+	// user functions are added to copy, shared templates are not modified
+	t, err := t.Clone()
+	if err == nil {
+		err = t.Funcs(userFuncs(ctx)).ExecuteTemplate(&buf, tpl, obj)
+	}
+	if err != nil {
+		logError("[template] Render failed", "template", tpl, "error", err)
+		MetricTemplateErrors.Inc(tpl)
+	}
 	return buf.String()
 }
 
@@ -374,47 +372,38 @@ func fmtRTT(d time.Duration) string {
 	return d.Round(scale / 100).String()
 }
 
-// convert UTC to MSK
-func utc2msk(t time.Time) time.Time {
-	loc, _ := time.LoadLocation("Europe/Moscow")
-	return t.In(loc)
-}
-
-// mapstructure decode with custom date format
-func mapstructureDecode(input interface{}, output interface{}) {
-	config := mapstructure.DecoderConfig{
-		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
-		Result:     &output,
-	}
-	decoder, _ := mapstructure.NewDecoder(&config)
-	decoder.Decode(input)
-}
-
-// debug log
-func logDebug(msg string) {
-	if CFG.DebugMode {
-		log.Printf("[%sDEBUG%s] %s", ColorCyan, ColorReset, msg)
-	}
+// print timestamp in user timezone
+func printUpdated(ctx context.Context, t time.Time) string {
+	return trf(ctx, "\n<i>Updated:</i> <code>%s</code>", localTime(ctx, t).Format("2006-01-02 15:04:05"))
 }
 
-// info log
-func logInfo(msg string) {
-	log.Printf("[%sINFO%s] %s", ColorGreen, ColorReset, msg)
+// taskPool - runs tasks concurrently with bounded number of workers
+type taskPool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
 }
 
-// warning log
-func logWarning(msg string) {
-	log.Printf("[%sWARNING%s] %s", ColorYellow, ColorReset, msg)
+// create task pool with max workers
+func newTaskPool(workers int) *taskPool {
+	return &taskPool{sem: make(chan struct{}, workers)}
 }
 
-// error log
-func logError(msg string) {
-	log.Printf("[%sERROR%s] %s", ColorRed, ColorReset, msg)
+// run task in pool, blocks while all workers are busy
+func (p *taskPool) Go(task func()) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		task()
+	}()
 }
 
-// print timestamp
-func printUpdated(t time.Time) string {
-	return fmt.Sprintf("\n<i>Updated:</i> <code>%s</code>", t.Format("2006-01-02 15:04:05"))
+// wait for all running tasks
+func (p *taskPool) Wait() {
+	p.wg.Wait()
 }
 
 // calculate optimal row length for many buttons
@@ -436,24 +425,26 @@ func calcRowLength(x int) int {
 func initBot() tgbotapi.UpdatesChannel {
 	var updates tgbotapi.UpdatesChannel
 	var err error
-	Bot, err = tgbotapi.NewBotAPI(CFG.BotToken)
+	cfg := getConfig()
+	Bot, err = tgbotapi.NewBotAPI(cfg.BotToken)
 	if err != nil {
 		log.Panic(err)
 	}
-	// Bot.Debug = CFG.DebugMode
-	logInfo(fmt.Sprintf("[init] Authorized on bot account %s", Bot.Self.UserName))
+	// Bot.Debug = cfg.DebugMode
+	Telegram = Bot
+	logInfo("[init] Authorized on bot account", "account", Bot.Self.UserName)
 
 	whInfo, _ := Bot.GetWebhookInfo()
-	logDebug(fmt.Sprintf("[init] Got webhook info: %v", whInfo.URL))
+	logDebug("[init] Got webhook info", "url", whInfo.URL)
 	// check webhook is set
-	if CFG.UseWebhook && whInfo.URL != CFG.WebhookURL+Bot.Token {
-		wh, _ := tgbotapi.NewWebhook(CFG.WebhookURL + Bot.Token)
+	if cfg.UseWebhook && whInfo.URL != cfg.WebhookURL+Bot.Token {
+		wh, _ := tgbotapi.NewWebhook(cfg.WebhookURL + Bot.Token)
 		_, err := Bot.Request(wh)
 		if err != nil {
 			log.Panic(err)
 		}
-		logDebug(fmt.Sprintf("[init] New webhook: %s", CFG.WebhookURL+Bot.Token))
-	} else if !CFG.UseWebhook && whInfo.URL != "" {
+		logDebug("[init] New webhook", "url", cfg.WebhookURL)
+	} else if !cfg.UseWebhook && whInfo.URL != "" {
 		_, err = Bot.Request(tgbotapi.DeleteWebhookConfig{})
 		if err != nil {
 			log.Panic(err)
@@ -461,158 +452,266 @@ func initBot() tgbotapi.UpdatesChannel {
 		logDebug("[init] Webhook deleted")
 	}
 	// set bot commands
-	_, err = Bot.Request(tgbotapi.NewSetMyCommands(BotCommands...))
+	setBotCommands()
+	// open user data store
+	Storage, err = openStore(cfg.Store, cfg.DataDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	logInfo("[init] Opened user data store", "store", fmt.Sprintf("%T", Storage))
+	// buttons with long payloads keep working after restart
+	loadCallbacks()
+	// open audit log, bot works without it
+	auditFile := cfg.AuditFile
+	if auditFile == "" {
+		dir := cfg.DataDir
+		if dir == "" {
+			dir = "data"
+		}
+		auditFile = filepath.Join(dir, "audit.log")
+	}
+	Audit, err = openAuditLog(auditFile, cfg.AuditMaxSize, cfg.AuditBackups)
 	if err != nil {
-		logError(fmt.Sprintf("[init] Set commands failed: %v", err))
+		Audit = nil
+		logError("[init] Open audit log failed", "file", auditFile, "error", err)
+	} else {
+		logInfo("[init] Opened audit log", "file", auditFile)
 	}
 	// init pingers
-	Pingers = make(map[int64]ping.Pinger)
+	Pingers = make(map[int64]*ping.Pinger)
 	// init user data
 	Data = make(map[int64]*UserData)
-	for uid := range Users {
+	for _, uid := range userIDs() {
 		initUserData(uid)
 	}
 	// init cron
 	Cron = cron.New()
 	// clear switches pool daily
-	id, err := Cron.AddFunc("0 0 * * *", func() { apiDelete("/pool") })
+	id, err := Cron.AddFunc("0 0 * * *", func() { getAPI().ClearPool(context.Background()) })
 	if err != nil {
-		logError(fmt.Sprintf("[init] [cron] failed to add clear pool entry: %v", err))
+		logError("[init] Add cron clear pool entry failed", "error", err)
 	} else {
-		logInfo(fmt.Sprintf("[init] [cron] added clear pool entry daily [%d]", id))
+		logInfo("[init] Added cron clear pool entry", "id", id)
 	}
 	Cron.Start()
-	if CFG.UseWebhook {
+	// metrics and health checks are served together with webhook or on listen port in polling mode
+	http.HandleFunc("/metrics", metricsHandler)
+	handleHealth(http.DefaultServeMux)
+	// health checks on separate port
+	if cfg.HealthPort != "" && cfg.HealthPort != cfg.ListenPort {
+		mux := http.NewServeMux()
+		handleHealth(mux)
+		serveHTTP(cfg.HealthPort, mux)
+		logInfo("[init] Serving health checks", "port", cfg.HealthPort)
+	}
+	if cfg.UseWebhook {
 		// serve http
-		go http.ListenAndServe(":"+CFG.ListenPort, nil)
+		serveHTTP(cfg.ListenPort, nil)
 		updates = Bot.ListenForWebhook("/" + Bot.Token)
-		logInfo(fmt.Sprintf("[init] Listening on port %s", CFG.ListenPort))
+		logInfo("[init] Listening", "port", cfg.ListenPort)
 	} else {
 		// start polling
 		updateConfig := tgbotapi.NewUpdate(0)
 		updateConfig.Timeout = 30
 		updates = Bot.GetUpdatesChan(updateConfig)
 		logInfo("[init] Start polling")
+		if cfg.ListenPort != "" {
+			serveHTTP(cfg.ListenPort, nil)
+			logInfo("[init] Serving metrics and health checks", "port", cfg.ListenPort)
+		}
 	}
 	return updates
 }
 
-// init empty user data
+// init user data from store or empty one
 func initUserData(uid int64) {
-	Data[uid] = &UserData{}
-}
-
-// init configuration
-func initConfig() error {
-	// load main config
-	err := readYML(&CFG, CFGFILE)
+	d, err := Storage.Load(uid)
 	if err != nil {
-		return err
+		if err != ErrNoUserData {
+			logError("[store] Load failed", "uid", uid, "error", err)
+		}
+		d = &UserData{}
 	}
-	// init users config
-	Users = make(map[int64]*UserConfig)
-	c, err := os.Open("config")
-	cFiles, err := c.Readdir(0)
-	if err != nil {
-		logError(fmt.Sprintf("[init] Read config files failed: %v", err))
+	// pingers do not survive restart
+	if d.Mode == "ping" {
+		d.Mode = "raw"
 	}
-	c.Close()
-	for _, v := range cFiles {
-		uid, err := strconv.ParseInt(strings.TrimSuffix(v.Name(), ".yml"), 10, 64)
-		if err == nil {
-			logDebug(fmt.Sprintf("[init] Loading config file: %s", v.Name()))
-			loadUserConfig(uid)
+	DataMu.Lock()
+	defer DataMu.Unlock()
+	// update worker of user may hold existing object, it is updated in place
+	if old, ok := Data[uid]; ok {
+		*old = *d
+		return
+	}
+	Data[uid] = d
+}
+
+// save user data to store
+func saveUserData(uid int64, d *UserData) {
+	if err := Storage.Save(uid, d); err != nil {
+		logError("[store] Save failed", "uid", uid, "error", err)
+	}
+}
+
+// copy user data to detect changes
+func (d *UserData) copy() UserData {
+	c := *d
+	c.History = append([]string(nil), d.History...)
+	return c
+}
+
+// add request to user history, newest first without duplicates
+func (d *UserData) addHistory(req string) {
+	h := []string{req}
+	for _, r := range d.History {
+		if r != req && len(h) < HistorySize {
+			h = append(h, r)
 		}
 	}
-	// init admin account
-	if !userIsAuthorized(CFG.Admin) {
-		logWarning("[init] Creating admin config")
-		initUserConfig(CFG.Admin, "admin")
-	}
-	// template functions
-	funcMap := template.FuncMap{
-		"fmtBytes": fmtBytes,
-		"fmtKbits": func(x uint) string { return fmtBytes(x*125, true) },
-		"fmtState": func(b bool) string {
-			if b {
-				return "enabled"
-			}
-			return "disabled"
-		},
-		"fmtHTML": html.EscapeString,
-		"inc":     func(x int) int { return x + 1 },
-		"add":     func(x, y int) int { return x + y },
-		"utc2msk": utc2msk,
+	d.History = h
+}
+
+// remember last viewed switch and port view from raw request
+func (d *UserData) trackView(raw string) {
+	cmd, args := splitArgs(raw)
+	ip := fullIP(cmd, true)
+	if ip == "" {
+		return
+	}
+	d.LastSwitch = ip
+	port, args := splitArgs(args)
+	if _, err := strconv.Atoi(port); err != nil {
+		return
+	}
+	if view, _ := splitArgs(args); view == "short" || view == "full" {
+		d.PortView = view
+	}
+}
+
+// add preferred port view to port request without explicit view
+func withPortView(raw string, view string) string {
+	cmd, args := splitArgs(raw)
+	port, other := splitArgs(args)
+	if view == "" || other != "" || fullIP(cmd, true) == "" {
+		return raw
 	}
-	// load templates
-	TPL, err = template.New("templates").Funcs(funcMap).ParseGlob("templates/*")
+	if _, err := strconv.Atoi(port); err != nil {
+		return raw
+	}
+	return raw + " " + view
+}
+
+// print user history
+func historyHandler(ctx context.Context, d *UserData) string {
+	if len(d.History) == 0 {
+		return tr(ctx, "History is empty")
+	}
+	res := tr(ctx, "Last requests:") + "\n"
+	for _, r := range d.History {
+		res += fmt.Sprintf("<code>%s</code>\n", html.EscapeString(r))
+	}
+	if d.LastSwitch != "" {
+		res += trf(ctx, "\nLast switch: <code>%s</code>", d.LastSwitch)
+	}
+	return res
+}
+
+// load config, users and templates, swap them atomically and return summary of changes,
+// old config is kept on errors
+func initConfig() (string, error) {
+	cfg, err := loadConfig(CFGFILE)
 	if err != nil {
-		logError(fmt.Sprintf("[template] Parse failed: %v", err))
-		return err
+		logError("[config] Load failed", "error", err)
+		return "", err
 	}
-	for _, t := range TPL.Templates() {
-		logDebug(fmt.Sprintf("[template] Loaded: %v", t.Name()))
+	users, err := loadUsers(cfg)
+	if err != nil {
+		logError("[config] Load users failed", "error", err)
+		return "", err
 	}
-	return nil
+	CFGMu.RLock()
+	oldTpl := TPL
+	CFGMu.RUnlock()
+	tpl, err := loadTemplates(oldTpl)
+	if err != nil {
+		logError("[template] Load failed", "error", err)
+		return "", err
+	}
+	if err = initLogger(cfg); err != nil {
+		logError("[config] Logger settings failed", "error", err)
+		return "", err
+	}
+	CFGMu.Lock()
+	// maintenance mode toggled by admin is kept until it is changed in config file
+	fileMaintenance := cfg.MaintenanceMode
+	if maintenanceOverride != nil && fileMaintenance == maintenanceFile {
+		cfg.MaintenanceMode = *maintenanceOverride
+	} else {
+		maintenanceOverride = nil
+	}
+	maintenanceFile = fileMaintenance
+	UsersMu.Lock()
+	oldCfg, oldUsers := CFG, Users
+	CFG, Users, TPL = cfg, users, tpl
+	// new api client drops cache and breaker state, so only on api settings change
+	if API == nil || apiConfigChanged(oldCfg, cfg) {
+		initAPI()
+	}
+	UsersMu.Unlock()
+	CFGMu.Unlock()
+	return configDiff(oldCfg, cfg, oldUsers, users, oldTpl, tpl), nil
 }
 
 // save main config to file
 func saveConfig() error {
-	return writeYML(&CFG, CFGFILE)
+	cfg := getConfig()
+	return writeYML(&cfg, CFGFILE)
 }
 
 // save user config to file
 func saveUserConfig(uid int64) error {
-	return writeYML(Users[uid], fmt.Sprintf("config/%d.yml", uid))
+	UsersMu.RLock()
+	u := *Users[uid]
+	UsersMu.RUnlock()
+	return writeYML(&u, fmt.Sprintf("config/%d.yml", uid))
 }
 
 // init new user config
-func initUserConfig(uid int64, name string) error {
+func initUserConfig(uid int64, name string, role string) error {
 	if name == "" {
 		name = fmt.Sprintf("user-%d", uid)
 	}
-	u := UserConfig{Name: name}
+	u := UserConfig{Name: name, Role: role}
+	UsersMu.Lock()
 	Users[uid] = &u
+	UsersMu.Unlock()
 	return saveUserConfig(uid)
 }
 
-// load user config from file
-func loadUserConfig(uid int64) error {
-	var u UserConfig
-	filename := fmt.Sprintf("config/%d.yml", uid)
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return initUserConfig(uid, "")
-	}
-	err := readYML(&u, filename)
-	if err != nil {
-		return err
-	}
-	Users[uid] = &u
-	return nil
-}
-
 // read config from yaml
 func readYML(cfg interface{}, filename string) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		logError(fmt.Sprintf("[config] Read file failed: %v", err))
+		logError("[config] Read file failed", "file", filename, "error", err)
 		return err
 	}
 	err = yaml.Unmarshal(data, cfg)
 	if err != nil {
-		logError(fmt.Sprintf("[config] Parse yaml failed: %v", err))
+		logError("[config] Parse yaml failed", "file", filename, "error", err)
 		return err
 	}
-	logInfo(fmt.Sprintf("[config] Loaded %s", filename))
+	logInfo("[config] Loaded", "file", filename)
 	return nil
 }
 
 // write config to yaml
 func writeYML(cfg interface{}, filename string) error {
+	// own changes should not trigger reload
+	markSelfWrite(filename)
 	// encode to yaml
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
-		logError(fmt.Sprintf("[config] YAML marshal failed: %v", err))
+		logError("[config] YAML marshal failed", "file", filename, "error", err)
 		return err
 	}
 	// attach document start and end strings
@@ -620,54 +719,89 @@ func writeYML(cfg interface{}, filename string) error {
 	data = append(data, []byte("...\n")...)
 	err = os.WriteFile(filename, data, 0644)
 	if err != nil {
-		logError(fmt.Sprintf("[config] Write file failed: %v", err))
+		logError("[config] Write file failed", "file", filename, "error", err)
 		return err
 	}
-	logInfo(fmt.Sprintf("[config] Saved %s", filename))
+	logInfo("[config] Saved", "file", filename)
 	return nil
 }
 
 // add/delete user
-func manageUser(args string, enabled bool) string {
+func manageUser(ctx context.Context, args string, enabled bool) string {
 	u, name := splitArgs(args)
 	uid, err := strconv.ParseInt(u, 10, 64)
 	if err != nil || uid == 0 {
-		return fmtErr("Wrong uid")
+		return fmtErr(tr(ctx, "Wrong uid"))
 	}
 	var msgUser, msgAdmin string
+	// removed user has no config and data after deletion
+	lang := userLang(uid)
 	if enabled && !userIsAuthorized(uid) {
-		initUserConfig(uid, name)
+		// use name from pending access request if not set
+		r := takeAccessRequest(uid, false)
+		if name == "" && r != nil {
+			name = r.Name
+		}
+		initUserConfig(uid, name, "")
 		initUserData(uid)
-		logInfo(fmt.Sprintf("[user] %d (%s) added", uid, Users[uid].Name))
+		if r != nil {
+			setUserLang(uid, r.Lang)
+			lang = userLang(uid)
+		}
+		logInfo("[user] Added", userFields(uid)...)
 		msgUser = "You are added to authorized users list."
-		msgAdmin = fmt.Sprintf("User <code>%d</code> <b>%s</b> added.", uid, Users[uid].Name)
+		msgAdmin = trf(ctx, "User <code>%d</code> <b>%s</b> added.", uid, userName(uid))
+		closeAccessRequest(r, nil, msgAdmin)
 	} else if !enabled && userIsAuthorized(uid) {
-		logInfo(fmt.Sprintf("[user] removing %d (%s)", uid, Users[uid].Name))
+		logInfo("[user] Removing", userFields(uid)...)
 		msgUser = "You are removed from authorized users list."
-		msgAdmin = fmt.Sprintf("User <code>%d</code> <b>%s</b> removed.", uid, Users[uid].Name)
+		msgAdmin = trf(ctx, "User <code>%d</code> <b>%s</b> removed.", uid, userName(uid))
+		UsersMu.Lock()
 		delete(Users, uid)
+		UsersMu.Unlock()
+		DataMu.Lock()
 		delete(Data, uid)
+		DataMu.Unlock()
 		os.Remove(fmt.Sprintf("config/%d.yml", uid))
-		os.Remove(fmt.Sprintf("data/%d.gob", uid))
+		if err := Storage.Delete(uid); err != nil {
+			logError("[store] Delete failed", "uid", uid, "error", err)
+		}
 	} else {
-		return "Nothing to do"
+		return tr(ctx, "Nothing to do")
 	}
-	sendTo(uid, msgUser)
+	sendTo(uid, translate(lang, msgUser))
 	return msgAdmin
 }
 
-// send text message with keyboard (both reply or inline) to user
+// send text message with keyboard (both reply or inline) to user, long text with inline keyboard
+// is split into pages, with reply keyboard it is sent in several messages
 func sendMessage(id int64, text string, kb interface{}) (tgbotapi.Message, error) {
-	if len(text) > 4096 {
-		logWarning(fmt.Sprintf("Message too long: %d", len(text)))
-		text = fmtErr("Message too long!")
+	var pages *messagePages
+	if textLen(text) > MaxMessageLength {
+		logWarning("[send] Message too long, splitting", "length", textLen(text))
+		MetricMessageTooLong.Inc("send")
+		if ikb, ok := kb.(tgbotapi.InlineKeyboardMarkup); ok {
+			pages = paginate(text, ikb, userLang(id))
+			text, kb = pages.view(1)
+		} else {
+			// keyboard is attached to the last part
+			parts := splitHTML(text, MaxMessageLength)
+			for _, p := range parts[:len(parts)-1] {
+				if _, err := sendTo(id, p); err != nil {
+					return tgbotapi.Message{}, err
+				}
+			}
+			text = parts[len(parts)-1]
+		}
 	}
 	msg := tgbotapi.NewMessage(id, text)
 	msg.ParseMode = tgbotapi.ModeHTML
 	msg.ReplyMarkup = kb
-	res, err := Bot.Send(msg)
+	res, err := Telegram.Send(msg)
 	if err != nil {
-		logError(fmt.Sprintf("[send] [%s] %v, msg: %#v ", Users[id].Name, err, msg))
+		logError("[send] Send failed", append(userFields(id), "error", err, "msg", fmt.Sprintf("%#v", msg))...)
+	} else if pages != nil {
+		savePages(&res, pages)
 	}
 	return res, err
 }
@@ -677,22 +811,38 @@ func clearReplyKeyboard(uid int64) {
 	k := tgbotapi.NewRemoveKeyboard(true)
 	// send and remove dummy message
 	m, _ := sendMessage(uid, "Dummy", k)
-	Bot.Request(tgbotapi.NewDeleteMessage(uid, m.MessageID))
+	Telegram.Request(tgbotapi.NewDeleteMessage(uid, m.MessageID))
 }
 
-// edit message with inline keyboard
+// edit message with inline keyboard, long text is split into pages
 func editMessage(m *tgbotapi.Message, textNew string, kbNew tgbotapi.InlineKeyboardMarkup, kbReplace bool) error {
-	if len(textNew) > 4096 {
-		logWarning(fmt.Sprintf("Message too long: %d", len(textNew)))
-		textNew = fmtErr("Message too long!")
-	}
 	var kb tgbotapi.InlineKeyboardMarkup
-	var msg tgbotapi.Chattable
 	if kbReplace {
 		kb = kbNew
 	} else {
 		kb = *m.ReplyMarkup
 	}
+	if textLen(textNew) <= MaxMessageLength {
+		// pages of previous text are not needed anymore
+		if textNew != "" {
+			dropPages(m)
+		}
+		return sendEdit(m, textNew, kb)
+	}
+	logWarning("[edit] Message too long, splitting", "length", textLen(textNew))
+	MetricMessageTooLong.Inc("edit")
+	pages := paginate(textNew, kb, userLang(m.Chat.ID))
+	textNew, kb = pages.view(1)
+	err := sendEdit(m, textNew, kb)
+	if err == nil {
+		savePages(m, pages)
+	}
+	return err
+}
+
+// edit message text and keyboard, only keyboard for empty text
+func sendEdit(m *tgbotapi.Message, textNew string, kb tgbotapi.InlineKeyboardMarkup) error {
+	var msg tgbotapi.Chattable
 	if textNew == "" {
 		tmp := tgbotapi.NewEditMessageReplyMarkup(m.Chat.ID, m.MessageID, kb)
 		msg = &tmp
@@ -701,9 +851,9 @@ func editMessage(m *tgbotapi.Message, textNew string, kbNew tgbotapi.InlineKeybo
 		tmp.ParseMode = tgbotapi.ModeHTML
 		msg = &tmp
 	}
-	_, err := Bot.Send(msg)
+	_, err := Telegram.Send(msg)
 	if err != nil {
-		logError(fmt.Sprintf("[edit] %v, msg: %#v ", err, msg))
+		logError("[edit] Edit failed", "error", err, "msg", fmt.Sprintf("%#v", msg))
 	}
 	return err
 }
@@ -715,7 +865,7 @@ func genKeyboard(matrix [][]map[string]string) tgbotapi.InlineKeyboardMarkup {
 		var row []tgbotapi.InlineKeyboardButton
 		for _, cols := range rows {
 			for key, val := range cols {
-				btn := tgbotapi.NewInlineKeyboardButtonData(key, val)
+				btn := tgbotapi.NewInlineKeyboardButtonData(key, encodeCallback(val))
 				row = append(row, btn)
 			}
 		}
@@ -727,8 +877,8 @@ func genKeyboard(matrix [][]map[string]string) tgbotapi.InlineKeyboardMarkup {
 }
 
 // shortcut for keyboard with one close button
-func closeButton() tgbotapi.InlineKeyboardMarkup {
-	return genKeyboard([][]map[string]string{{{"close": "close"}}})
+func closeButton(ctx context.Context) tgbotapi.InlineKeyboardMarkup {
+	return genKeyboard([][]map[string]string{{{tr(ctx, "close"): "close"}}})
 }
 
 // generate pagination keyboard row for page/total
@@ -814,120 +964,151 @@ func sendTo(id int64, text string) (tgbotapi.Message, error) {
 
 // shortcut for text message with close button
 func sendAlert(id int64, text string) (tgbotapi.Message, error) {
-	return sendMessage(id, text, closeButton())
+	return sendMessage(id, text, closeButton(withUID(context.Background(), id)))
+}
+
+// send message to all admins and admin group, except admin who made changes
+func notifyAdmins(text string, except int64) {
+	cfg := getConfig()
+	for _, id := range adminIDs() {
+		if id != except {
+			sendTo(id, text)
+		}
+	}
+	if cfg.AdminGroup != 0 {
+		sendTo(cfg.AdminGroup, text)
+	}
+}
+
+// send message translated to language of each admin, time args are printed in admin timezone
+func notifyAdminsf(except int64, format string, args ...interface{}) {
+	send := func(id int64) {
+		a := make([]interface{}, len(args))
+		for i, arg := range args {
+			if t, ok := arg.(time.Time); ok {
+				arg = t.In(userLocation(id)).Format("15:04")
+			}
+			a[i] = arg
+		}
+		sendTo(id, fmt.Sprintf(translate(userLang(id), format), a...))
+	}
+	cfg := getConfig()
+	for _, id := range adminIDs() {
+		if id != except {
+			send(id)
+		}
+	}
+	if cfg.AdminGroup != 0 {
+		send(cfg.AdminGroup)
+	}
 }
 
 // broadcast message to all users
-func broadcastSend(text string) string {
+func broadcastSend(ctx context.Context, text string) string {
 	var res string
 	if text == "" {
-		return fmtErr("empty message")
+		return fmtErr(tr(ctx, "empty message"))
 	}
-	for uid := range Users {
+	for _, uid := range userIDs() {
 		_, err := sendTo(uid, text)
 		if err == nil {
 			res += fmt.Sprintf("%d OK\n", uid)
 		} else {
-			res += fmt.Sprintf("%d failed: %v\n", uid, err)
+			res += trf(ctx, "%d failed: %v\n", uid, err)
 		}
 	}
 	return res
 }
 
-// universal api request
-func requestAPI(method string, endpoint string, args map[string]interface{}) (map[string]interface{}, error) {
-	logDebug(fmt.Sprintf("[API %s] endpoint: %s, args: %+v", method, endpoint, args))
-	var res map[string]interface{}
-	if endpoint == "" {
-		return res, errors.New("Empty endpoint")
+// logger adapter for api client
+type apiLogger struct{}
+
+func (apiLogger) Debug(msg string, kv ...interface{})   { logDebug(msg, kv...) }
+func (apiLogger) Warning(msg string, kv ...interface{}) { logWarning(msg, kv...) }
+func (apiLogger) Error(msg string, kv ...interface{})   { logError(msg, kv...) }
+
+// init inkotools api client, CFGMu must be locked by caller
+func initAPI() {
+	API = inkotools.NewClient(CFG.InkoToolsAPI)
+	API.Log = apiLogger{}
+	if CFG.APITimeout > 0 {
+		API.Timeout = CFG.APITimeout
 	}
-	// pack arguments to body
-	var reqBody *bytes.Buffer
-	if len(args) > 0 {
-		reqData, err := json.Marshal(args)
-		if err != nil {
-			logError(fmt.Sprintf("[API %s] Pack args to json failed: %v, args: %+v", method, err, args))
-			return res, errors.New("Packing arguments to json failed")
-		}
-		reqBody = bytes.NewBuffer(reqData)
+	if CFG.APISlowTimeout > 0 {
+		API.SlowTimeout = CFG.APISlowTimeout
 	}
-	// ensure that there is no double // symbols in url
-	url := strings.TrimRight(CFG.InkoToolsAPI, "/") + "/" + strings.TrimLeft(endpoint, "/")
-	// make request
-	var req *http.Request
-	var err error
-	// workaround typed and untyped nil
-	if reqBody == nil {
-		req, err = http.NewRequest(method, url, nil)
-	} else {
-		req, err = http.NewRequest(method, url, reqBody)
+	if CFG.APIRetries > 0 {
+		API.Retries = CFG.APIRetries
 	}
-	if err != nil {
-		logError(fmt.Sprintf("[API %s] Creating request object failed: %v, url: %s", method, err, url))
-		return res, errors.New("Creating request object failed")
+	if CFG.APIDownAfter > 0 {
+		API.Breaker.Threshold = CFG.APIDownAfter
 	}
-	req.Header.Add("Content-Type", "application/json")
-	// send json request to api
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logError(fmt.Sprintf("[API %s] Request failed: %v, endpoint: %s", method, err, endpoint))
-		return res, errors.New("API request failed")
+	if CFG.APIDownCooldown > 0 {
+		API.Breaker.Cooldown = CFG.APIDownCooldown
 	}
-	defer resp.Body.Close()
-	// parse response
-	err = json.NewDecoder(resp.Body).Decode(&res)
-	if err != nil {
-		logError(fmt.Sprintf("[API %s] Response json decode failed: %v, endpoint: %s", method, err, endpoint))
-		return res, errors.New("API response decode failed")
-	}
-	// if we have no errors from api - return result
-	if resp.StatusCode < 400 {
-		logDebug(fmt.Sprintf("[API %s] Response: %+v", method, res))
-		return res, nil
-	}
-	// parse errors from api
-	if res["detail"] != nil {
-		logWarning(fmt.Sprintf("[API %s] Returned %d error: %v, endpoint: %s", method, resp.StatusCode, res["detail"], endpoint))
-		switch res["detail"].(type) {
-		case string:
-			return res, errors.New(res["detail"].(string))
-		case []interface{}:
-			return res, fmt.Errorf("%d", resp.StatusCode)
+	API.Breaker.OnChange = apiStateChanged
+	API.OnResponse = apiObserve
+	// override cache ttl for configured endpoint classes
+	if len(CFG.APICache) > 0 {
+		ttl := make(map[string]time.Duration)
+		for class, d := range inkotools.DefaultCacheTTL {
+			ttl[class] = d
 		}
+		for class, d := range CFG.APICache {
+			ttl[class] = d
+		}
+		API.CacheTTL = ttl
 	}
-	logError(fmt.Sprintf("[API %s] Returned %d error, raw response: %#v, endpoint: %s", method, resp.StatusCode, res, endpoint))
-	return res, fmt.Errorf("%d", resp.StatusCode)
 }
 
-// api get request shortcut
-func apiGet(endpoint string) (map[string]interface{}, error) {
-	return requestAPI("GET", endpoint, map[string]interface{}{})
+// print api cache stats
+func cacheStats(ctx context.Context) string {
+	cache := getAPI().Cache
+	if cache == nil {
+		return tr(ctx, "Cache is disabled")
+	}
+	res := trf(ctx, "Cache entries: <code>%d</code>\n", cache.Len())
+	stats := cache.Stats()
+	classes := make([]string, 0, len(stats))
+	for class := range stats {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		s := stats[class]
+		res += fmt.Sprintf("<i>%s:</i> <code>%d/%d (%.1f%%)</code>\n",
+			class, s.Hits, s.Hits+s.Misses, s.HitRate())
+	}
+	return res
 }
 
-// api delete request shortcut
-func apiDelete(endpoint string) (map[string]interface{}, error) {
-	return requestAPI("DELETE", endpoint, map[string]interface{}{})
+// notify admin when api goes down or up
+func apiStateChanged(down bool, since time.Time) {
+	var msg string
+	if down {
+		logError("[API] Circuit opened", "since", since.Format(time.RFC3339))
+		msg = "&#9888; inkotools API is down since <code>%s</code>"
+	} else {
+		logInfo("[API] Circuit closed", "since", since.Format(time.RFC3339))
+		msg = "&#9989; inkotools API is up again, was down since <code>%s</code>"
+	}
+	notifyAdminsf(0, msg, since)
 }
 
 // get switch summary and format it with template
-func swSummary(ip string, style string) (string, error) {
+func swSummary(ctx context.Context, ip string, style string) (string, error) {
 	var res, template string
-	var err error
-	var sw Switch
 	switch style {
 	case "short":
 		template = "sw.short.tmpl"
 	default:
 		template = "sw.tmpl"
 	}
-	resp, err := apiGet(fmt.Sprintf("/sw/%s/", ip))
+	sw, err := getAPI().GetSwitch(ctx, ip)
 	if err != nil {
-		return fmtErr(err.Error()), err
+		return fmtErr(errText(ctx, err)), err
 	}
-	// serialize data from returned map to struct
-	mapstructure.Decode(resp["data"], &sw)
-	res = fmtObj(sw, template)
+	res = fmtObj(ctx, sw, template)
 	if !sw.Status {
 		err = errors.New("unavailable")
 	}
@@ -935,79 +1116,108 @@ func swSummary(ip string, style string) (string, error) {
 }
 
 // get switch free ports and format them with template
-func freePorts(ip string) (string, error) {
+func freePorts(ctx context.Context, ip string) (string, error) {
 	var res string
-	var ports []Port
-
-	resp, err := apiGet(fmt.Sprintf("/sw/%s/freeports/", ip))
+	ports, err := getAPI().GetFreePorts(ctx, ip)
 	if err != nil {
 		return res, err
 	}
-	mapstructure.Decode(resp["data"], &ports)
 	if len(ports) == 0 {
-		res = "\n<code>Not found</code>"
+		res = "\n<code>" + tr(ctx, "Not found") + "</code>"
 	} else {
-		res = fmtObj(ports, "port")
+		res = fmtObj(ctx, ports, "port")
 	}
 	return res, err
 }
 
-// get switch access ports and format them with template
-func accessPorts(ip string) (string, error) {
+// get switch access ports and format them with template, number of ports is returned for buttons
+func accessPorts(ctx context.Context, ip string) (string, int, error) {
 	var res string
-	var ports []Port
-
-	resp, err := apiGet(fmt.Sprintf("/sw/%s/accessports/", ip))
+	ports, err := getAPI().GetAccessPorts(ctx, ip)
 	if err != nil {
-		return res, err
+		return res, 0, err
 	}
-	mapstructure.Decode(resp["data"], &ports)
 	if len(ports) == 0 {
-		res = "No access ports found"
+		res = tr(ctx, "No access ports found")
 	} else {
-		res = fmtObj(ports, "port")
+		res = fmtObj(ctx, ports, "port")
 	}
-	return res, err
+	return res, len(ports), err
 }
 
-// get last logs from api and format with template
-func getLastLogs(endpoint string, offset int, limit int) (string, bool, error) {
-	var res string
-	var events []LogEvent
+// format log events with template
+func fmtLogs(ctx context.Context, events []inkotools.LogEvent, limit int) (string, bool) {
+	isLastPage := len(events) < limit
+	return fmtObj(ctx, events, "log.tmpl"), isLastPage
+}
 
-	resp, err := apiGet(fmt.Sprintf("%s/log?offset=%d&limit=%d", endpoint, offset, limit))
+// get switch logs and format with template
+func swLogs(ctx context.Context, ip string, offset int, limit int) (string, bool, error) {
+	events, err := getAPI().GetSwitchLogs(ctx, ip, offset, limit)
 	if err != nil {
-		return res, true, err
+		return "", true, err
 	}
-	mapstructureDecode(resp["data"], &events)
-	res = fmtObj(events, "log.tmpl")
-	isLastPage := len(events) < limit
+	res, isLastPage := fmtLogs(ctx, events, limit)
 	return res, isLastPage, err
 }
 
-// shortcut for switch logs
-func swLogs(ip string, offset int, limit int) (string, bool, error) {
-	return getLastLogs(fmt.Sprintf("/sw/%s", ip), offset, limit)
+// get port logs and format with template
+func portLogs(ctx context.Context, ip string, port string, offset int, limit int) (string, bool, error) {
+	events, err := getAPI().GetPortLogs(ctx, ip, port, offset, limit)
+	if err != nil {
+		return "", true, err
+	}
+	res, isLastPage := fmtLogs(ctx, events, limit)
+	return res, isLastPage, err
 }
 
-// shortcut for port logs
-func portLogs(ip string, port string, offset int, limit int) (string, bool, error) {
-	return getLastLogs(fmt.Sprintf("/sw/%s/ports/%s", ip, port), offset, limit)
+// get port summary and format it with template
+func portSummary(ctx context.Context, ip string, port string, style string) (string, error) {
+	pInfo, err := getPortSummary(ctx, ip, port, style)
+	if err != nil {
+		return "", err
+	}
+	// errors are escalated to template
+	return fmtObj(ctx, pInfo, "port.tmpl") + printUpdated(ctx, time.Now()), nil
 }
 
-// get port summary and format it with template
-func portSummary(ip string, port string, style string) (string, error) {
-	var res string        // result string
+// get port summary, errors of optional requests are saved in summary
+func getPortSummary(ctx context.Context, ip string, port string, style string) (PortSummary, error) {
 	var pInfo PortSummary // main port summary object
 	var accessPorts []int // list of access ports (for checks)
-	var arpTmp []ARPEntry // for arp table deduplication
-
-	// get slots info, return on error
-	resp, err := apiGet(fmt.Sprintf("/sw/%s/ports/%s/", ip, port))
-	if err != nil {
-		return res, err
+	var slotsErr, accessErr error
+	api := getAPI()
+	cfg := getConfig()
+
+	// total deadline for all requests
+	timeout := cfg.SummaryTimeout
+	if timeout <= 0 {
+		timeout = DefaultSummaryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// all independent requests share one bounded pool
+	workers := cfg.SummaryWorkers
+	if workers <= 0 {
+		workers = DefaultSummaryWorkers
+	}
+	pool := newTaskPool(workers)
+
+	// get slots info and list of access ports
+	pool.Go(func() {
+		pInfo.Slots, slotsErr = api.GetPortSlots(ctx, ip, port)
+	})
+	pool.Go(func() {
+		accessPorts, accessErr = api.GetAccessPortNumbers(ctx, ip)
+	})
+	pool.Wait()
+	// return on slots error, without access ports every port would be treated as transit
+	if slotsErr != nil {
+		return pInfo, slotsErr
+	}
+	if accessErr != nil {
+		return pInfo, accessErr
 	}
-	mapstructure.Decode(resp["data"], &pInfo.Slots)
 
 	// set common port values
 	pInfo.PortNumber = pInfo.Slots[0].Port
@@ -1018,47 +1228,43 @@ func portSummary(ip string, port string, style string) (string, error) {
 			break
 		}
 	}
+	// check if port is transit
+	portIsTransit := !intInList(pInfo.PortNumber, accessPorts)
 
 	// get linkdown count
-	resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/linkdowncount", ip, port))
-	if err == nil {
-		mapstructure.Decode(resp["data"], &pInfo.LinkDownCount)
-	}
+	pool.Go(func() {
+		if count, err := api.GetPortLinkDownCount(ctx, ip, port); err == nil {
+			pInfo.LinkDownCount = count
+		}
+	})
 
 	// get last log event
-	s, _, err := portLogs(ip, port, 0, 1)
-	pInfo.LastLogEvent = strings.Trim(s, "\n")
+	pool.Go(func() {
+		s, _, _ := portLogs(ctx, ip, port, 0, 1)
+		pInfo.LastLogEvent = strings.Trim(s, "\n")
+	})
 
 	// get port counters
-	resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/counters", ip, port))
-	if err != nil {
-		pInfo.Counters.Error = err.Error()
-	} else {
-		mapstructure.Decode(resp["data"], &pInfo.Counters)
-	}
-
-	// check if port is transit
-	portIsTransit := false
-	// get list of access ports
-	resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/", ip))
-	if err == nil {
-		mapstructure.Decode(resp["data"].(map[string]interface{})["access_ports"], &accessPorts)
-	}
-	if !intInList(pInfo.PortNumber, accessPorts) {
-		portIsTransit = true
-	}
+	pool.Go(func() {
+		var err error
+		pInfo.Counters.PortCounters, err = api.GetPortCounters(ctx, ip, port)
+		if err != nil {
+			pInfo.Counters.Error = errText(ctx, err)
+		}
+	})
 
 	// get mac table only if link is up
 	if pInfo.LinkUp {
 		if portIsTransit {
-			pInfo.MAC.Error = "Transit ports are not supported"
+			pInfo.MAC.Error = tr(ctx, "Transit ports are not supported")
 		} else {
-			resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/mac", ip, port))
-			if err != nil {
-				pInfo.MAC.Error = err.Error()
-			} else {
-				mapstructure.Decode(resp["data"], &pInfo.MAC.Entries)
-			}
+			pool.Go(func() {
+				var err error
+				pInfo.MAC.Entries, err = api.GetPortMAC(ctx, ip, port)
+				if err != nil {
+					pInfo.MAC.Error = errText(ctx, err)
+				}
+			})
 		}
 	}
 
@@ -1066,96 +1272,113 @@ func portSummary(ip string, port string, style string) (string, error) {
 	if style == "full" {
 
 		// get port bandwidth
-		resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/bandwidth", ip, port))
-		if err == nil {
-			mapstructure.Decode(resp["data"], &pInfo.Bandwidth)
-		}
+		pool.Go(func() {
+			if bw, err := api.GetPortBandwidth(ctx, ip, port); err == nil {
+				pInfo.Bandwidth = bw
+			}
+		})
 
 		// get vlan
-		resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/vlan", ip, port))
-		if err != nil {
-			pInfo.VLAN.Error = err.Error()
-		} else {
-			mapstructure.Decode(resp["data"], &pInfo.VLAN)
-		}
+		pool.Go(func() {
+			var err error
+			pInfo.VLAN.PortVlan, err = api.GetPortVlan(ctx, ip, port)
+			if err != nil {
+				pInfo.VLAN.Error = errText(ctx, err)
+			}
+		})
 
 		// all other data only for access ports
 		if portIsTransit {
-			e := "Transit ports are not supported"
+			e := tr(ctx, "Transit ports are not supported")
 			pInfo.ACL.Error = e
 			pInfo.Multicast.Error = e
 			pInfo.ARP.Error = e
 		} else {
 
 			// get acl
-			resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/acl", ip, port))
-			if err != nil {
-				pInfo.ACL.Error = err.Error()
-			} else {
-				mapstructure.Decode(resp["data"], &pInfo.ACL.Entries)
-			}
+			pool.Go(func() {
+				var err error
+				pInfo.ACL.Entries, err = api.GetPortACL(ctx, ip, port)
+				if err != nil {
+					pInfo.ACL.Error = errText(ctx, err)
+				}
+			})
 
 			// get multicast data
-			resp, err = apiGet(fmt.Sprintf("/sw/%s/multicast", ip))
-			if err != nil {
-				pInfo.Multicast.Error = err.Error()
-			} else {
-				mapstructure.Decode(resp["data"], &pInfo.Multicast)
-				// check if port is member of mvlan
-				pInfo.Multicast.State = intInList(pInfo.PortNumber, pInfo.Multicast.MemberPorts)
-				if pInfo.Multicast.State {
-					// mcast filters
-					resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/mcast/filters", ip, port))
-					if err == nil {
-						mapstructure.Decode(resp["data"], &pInfo.Multicast.Filters)
-					}
-					if pInfo.LinkUp {
-						// mcast groups
-						resp, err = apiGet(fmt.Sprintf("/sw/%s/ports/%s/mcast/groups", ip, port))
-						if err == nil {
-							mapstructure.Decode(resp["data"], &pInfo.Multicast.Groups)
-						}
-					}
+			pool.Go(func() {
+				var err error
+				pInfo.Multicast.PortMulticast, err = api.GetMulticast(ctx, ip)
+				if err != nil {
+					pInfo.Multicast.Error = errText(ctx, err)
+				} else {
+					// check if port is member of mvlan
+					pInfo.Multicast.State = intInList(pInfo.PortNumber, pInfo.Multicast.MemberPorts)
+				}
+			})
+		}
+
+	} // end full style
+
+	// wait for requests which next ones depend on
+	pool.Wait()
+
+	if style == "full" && !portIsTransit {
+
+		if pInfo.Multicast.State {
+			// mcast filters
+			pool.Go(func() {
+				if filters, err := api.GetPortMcastFilters(ctx, ip, port); err == nil {
+					pInfo.Multicast.Filters = filters
 				}
+			})
+			if pInfo.LinkUp {
+				// mcast groups
+				pool.Go(func() {
+					if groups, err := api.GetPortMcastGroups(ctx, ip, port); err == nil {
+						pInfo.Multicast.Groups = groups
+					}
+				})
 			}
+		}
 
-			// get arp only if mac address table is not empty and not more than 5 addresses
-			if x := len(pInfo.MAC.Entries); x > 0 && x < 5 {
-				// get arp table for acl permit ip
-				for _, a := range pInfo.ACL.Entries {
-					if a.Mode == "permit" {
-						if a.IP == "0.0.0.0" {
-							// skip arpsearch for 0.0.0.0
-							logWarning(fmt.Sprintf("[%s][%s] Invalid permit ACL", ip, port))
-							continue
-						}
-						resp, err = requestAPI("POST", "/arpsearch", map[string]interface{}{"ip": a.IP})
-						if err != nil {
-							logWarning(fmt.Sprintf("[ARP] failed to get %s", a.IP))
-							pInfo.ARP.Error += err.Error() + "\n"
-						} else {
-							mapstructure.Decode(resp["data"], &arpTmp)
-							// append to global arp
-							pInfo.ARP.Entries = append(pInfo.ARP.Entries, arpTmp...)
-						}
+		// get arp only if mac address table is not empty and not more than 5 addresses
+		if x := len(pInfo.MAC.Entries); x > 0 && x < 5 {
+			var queries []inkotools.ARPQuery
+			// get arp table for acl permit ip
+			for _, a := range pInfo.ACL.Entries {
+				if a.Mode == "permit" {
+					if a.IP == "0.0.0.0" {
+						// skip arpsearch for 0.0.0.0
+						logWarning("[portSummary] Invalid permit ACL", "ip", ip, "port", port)
+						continue
 					}
+					queries = append(queries, inkotools.ARPQuery{IP: a.IP})
 				}
-				// get arp for each mac address
-				for _, m := range pInfo.MAC.Entries {
-					resp, err = requestAPI("POST", "/arpsearch", map[string]interface{}{"mac": m.Mac, "src_sw_ip": ip})
-					if err != nil {
-						logWarning(fmt.Sprintf("[ARP] failed to get %s", m.Mac))
-						pInfo.ARP.Error += err.Error() + "\n"
-					} else {
-						mapstructure.Decode(resp["data"], &arpTmp)
-						// append to global arp
-						pInfo.ARP.Entries = append(pInfo.ARP.Entries, arpTmp...)
+			}
+			// get arp for each mac address
+			for _, m := range pInfo.MAC.Entries {
+				queries = append(queries, inkotools.ARPQuery{Mac: m.Mac, SrcSwIP: ip})
+			}
+			// results are collected by index to keep order of entries
+			arpEntries := make([][]inkotools.ARPEntry, len(queries))
+			arpErrors := make([]error, len(queries))
+			for i, q := range queries {
+				i, q := i, q
+				pool.Go(func() {
+					arpEntries[i], arpErrors[i] = api.ArpSearch(ctx, q)
+					if arpErrors[i] != nil {
+						logWarning("[portSummary] ARP search failed", "ip", q.IP, "mac", q.Mac, "error", arpErrors[i])
 					}
+				})
+			}
+			pool.Wait()
+			for i := range queries {
+				if arpErrors[i] != nil {
+					pInfo.ARP.Error += arpErrors[i].Error() + "\n"
+					continue
 				}
-				// remove duplicate entries from global arp table
-				arpTmp = pInfo.ARP.Entries
-				pInfo.ARP.Entries = nil
-				for _, a := range arpTmp {
+				// append to global arp skipping duplicates
+				for _, a := range arpEntries[i] {
 					dup := false
 					for _, u := range pInfo.ARP.Entries {
 						if u == a {
@@ -1167,106 +1390,123 @@ func portSummary(ip string, port string, style string) (string, error) {
 						pInfo.ARP.Entries = append(pInfo.ARP.Entries, a)
 					}
 				}
-			} // end arp
-
-		} // end access ports
+			}
+		} // end arp
 
-	} // end full style
+	}
 
-	logDebug(fmt.Sprintf("[portSummary] pInfo: %+v", pInfo))
+	pool.Wait()
 
-	res += fmtObj(pInfo, "port.tmpl")
-	res += printUpdated(time.Now())
-	// clear previous errors (escalated to template)
-	err = nil
-	return res, err
+	return pInfo, nil
 }
 
 // clear port counters
-func portClear(ip string, port string) string {
-	resp, err := apiDelete(fmt.Sprintf("/sw/%s/ports/%s/counters", ip, port))
+func portClear(ctx context.Context, ip string, port string) string {
+	res, err := getAPI().ClearPortCounters(ctx, ip, port)
+	e := AuditEntry{Kind: "action", Action: "port clear", IP: ip, Port: port, Result: "ok"}
 	if err != nil {
-		return fmtErr(err.Error())
+		e.Result, e.Error = "error", err.Error()
 	}
-	if resp["detail"] == nil {
-		logError(fmt.Sprintf("[clear %s %s] API returned no detail, raw data: %v", ip, port, resp))
-		return fmtErr("Empty response")
+	audit(ctx, e)
+	if err != nil {
+		return fmtErr(errText(ctx, err))
 	}
-	return resp["detail"].(string)
+	return res
 }
 
 // get ip summary
-func ipCalc(ip string) string {
-	var res string
-	resp, err := apiGet(fmt.Sprintf("/ipcalc/%s/", ip))
+func ipCalc(ctx context.Context, ip string) string {
+	calc, err := getAPI().IPCalc(ctx, ip)
 	if err != nil {
-		return fmtErr(err.Error())
+		return fmtErr(errText(ctx, err))
 	}
-	var calc IPCalc
-	mapstructure.Decode(resp["data"], &calc)
-	res += fmtObj(calc, "ipcalc.tmpl")
-	return res
+	return fmtObj(ctx, calc, "ipcalc.tmpl")
 }
 
 // TELEGRAM COMMANDS HANDLERS
 
-// new user handler
-func newUserHandler(u *tgbotapi.User) {
-	msg := fmt.Sprintf("User <a href=\"tg://user?id=%d\">%s</a> "+
-		" requests authorization:\nid: <code>%d</code>", u.ID, u, u.ID)
-	sendTo(CFG.Admin, msg)
-	sendTo(u.ID, "Your request is accepted. Waiting confirmation from admin.")
-}
-
 // admin command handler
-func adminHandler(msg string) string {
+func adminHandler(ctx context.Context, msg string) string {
 	cmd, arg := splitArgs(msg)
 	var res string
 	var err error
+	if _, known := Permissions["admin "+cmd]; known && !can(ctx, "admin "+cmd) {
+		return tr(ctx, MsgNoPermission)
+	}
 	switch cmd {
 	case "list":
-		for id, u := range Users {
+		for _, id := range userIDs() {
 			res += fmt.Sprintf(
-				"<code>%d</code> - <a href=\"tg://user?id=%d\">%s</a>\n", id, id, u.Name)
+				"<code>%d</code> - <a href=\"tg://user?id=%d\">%s</a>\n", id, id, userName(id))
 		}
 	case "add":
-		res = manageUser(arg, true)
+		res = manageUser(ctx, arg, true)
 	case "del":
-		res = manageUser(arg, false)
+		res = manageUser(ctx, arg, false)
+	case "role":
+		res = setUserRole(ctx, arg)
 	case "send":
 		user, text := splitArgs(arg)
 		id, _ := strconv.ParseInt(user, 10, 64)
 		_, err = sendTo(id, text)
 		if err == nil {
-			res = "Message sent"
+			res = tr(ctx, "Message sent")
 		} else {
-			res = fmt.Sprintf("Message not sent: %v", err)
+			res = trf(ctx, "Message not sent: %v", err)
 		}
 	case "broadcast":
-		res = broadcastSend(arg)
+		res = broadcastSend(ctx, arg)
 	case "reload":
-		err = initConfig()
+		var diff string
+		diff, err = initConfig()
 		if err != nil {
-			res = "Failed"
+			res = tr(ctx, "Failed") + fmtErr(html.EscapeString(err.Error()))
+		} else if diff == "" {
+			res = tr(ctx, "Config reloaded, nothing changed")
 		} else {
-			res = "Config reloaded"
+			res = trf(ctx, "Config reloaded:\n%s", diff)
+		}
+	case "audit":
+		res = auditHandler(ctx, arg)
+	case "log":
+		res = logLevelHandler(ctx, arg)
+	case "cache":
+		if arg == "flush" {
+			if cache := getAPI().Cache; cache != nil {
+				cache.Flush()
+			}
+			res = tr(ctx, "Cache flushed")
+		} else {
+			res = cacheStats(ctx)
 		}
 	case "maintenance":
+		CFGMu.Lock()
 		switch arg {
-		case "on":
-			CFG.MaintenanceMode = true
-		case "off":
-			CFG.MaintenanceMode = false
+		case "on", "off":
+			mode := arg == "on"
+			CFG.MaintenanceMode = mode
+			maintenanceOverride = &mode
 		}
-		res = fmt.Sprintf("Maintenance: %v", CFG.MaintenanceMode)
+		res = trf(ctx, "Maintenance: %v", CFG.MaintenanceMode)
+		CFGMu.Unlock()
 	default:
-		res = HELPADMIN
+		return tr(ctx, HELPADMIN)
+	}
+	// attribute changes to admin who made them
+	if cmd == "maintenance" && arg == "" {
+		return res
+	}
+	switch cmd {
+	case "add", "del", "role", "broadcast", "reload", "maintenance":
+		uid := ctxUID(ctx)
+		logInfo("[admin] Command", append(userFields(uid), "cmd", msg)...)
+		notifyAdmins(fmt.Sprintf("<i>%s:</i> %s", html.EscapeString(userName(uid)), res), uid)
 	}
 	return res
 }
 
 // parse raw input handler
-func rawHandler(raw string) (string, tgbotapi.InlineKeyboardMarkup) {
+func rawHandler(ctx context.Context, raw string) (string, tgbotapi.InlineKeyboardMarkup) {
 	var res string                       // text message result
 	var kb tgbotapi.InlineKeyboardMarkup // inline keyboard markup
 	cmd, args := splitArgs(raw)
@@ -1277,61 +1517,74 @@ func rawHandler(raw string) (string, tgbotapi.InlineKeyboardMarkup) {
 		// skip
 	// cmd is ip address
 	case ip != "":
-		// ip is sw ip
-		if fullIP(ip, true) != "" {
-			res, kb = swHandler(ip, args)
-			// ip is client ip
-		} else {
-			res = fmt.Sprintf("%s is not a switch ip", ip)
+		switch ipClass(ip) {
+		case ipSwitch:
+			res, kb = swHandler(ctx, ip, args)
+		case ipClient:
+			res = trf(ctx, "%s is a client ip, not a switch ip", ip)
+		default:
+			res = trf(ctx, "%s is not a switch ip", ip)
 		}
 	default:
 		// search in db by default
-		res, kb = searchHandler(raw, 1)
+		if can(ctx, "search") {
+			res, kb = searchHandler(ctx, raw, 1)
+		} else {
+			res = tr(ctx, MsgNoPermission)
+		}
 	}
 	// default keyboard with close button
 	if len(kb.InlineKeyboard) == 0 {
-		kb = closeButton()
+		kb = closeButton(ctx)
 	}
 	return res, kb
 }
 
 // switch ip handler
-func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
+func swHandler(ctx context.Context, ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 	var res string // text message result
 	var err error
 	var kb tgbotapi.InlineKeyboardMarkup // inline keyboard markup
 	pView := []string{"short", "full"}   // view styles for port summary
 	idx := 0                             // default index - short view
 	// offset := 0                          // start offset for logs
-	limit := 5 // default limit for logs
-	logDebug(fmt.Sprintf("[swHandler] ip: %s, args: '%s'", ip, args))
+	limit := logPageSize
+	logDebug("[swHandler] Request", "uid", ctxUID(ctx), "ip", ip, "args", args)
+	// refresh button bypasses api cache
+	if args == "refresh" || strings.HasSuffix(args, " refresh") {
+		ctx = inkotools.NoCache(ctx)
+		args = strings.TrimSpace(strings.TrimSuffix(args, "refresh"))
+	}
+	if !can(ctx, "switch") {
+		return tr(ctx, MsgNoPermission), kb
+	}
 	port := ""
 	// check first arg
 	action, args := splitArgs(args)
 	switch action {
 	// free ports handler
 	case "free":
-		res += "Free ports:"
-		s, err := freePorts(ip)
+		res += tr(ctx, "Free ports:")
+		s, err := freePorts(ctx, ip)
 		if err != nil {
-			res += fmt.Sprintf("\n<code>%s</code>", err.Error())
+			res += fmtErr(errText(ctx, err))
 		} else {
 			res += s
 			kb = genKeyboard([][]map[string]string{{
 				{ip: fmt.Sprintf("raw edit %s", ip)},
-				{"close": "close"},
+				exportButton(ctx, ip+" free"),
+				{tr(ctx, "close"): "close"},
 			}})
 		}
 		return res, kb
 	// access ports handler
 	case "access":
-		s, err := accessPorts(ip)
+		s, pCnt, err := accessPorts(ctx, ip)
 		if err != nil {
-			res += fmt.Sprintf("\n<code>%s</code>", err.Error())
+			res += fmtErr(errText(ctx, err))
 		} else {
 			res += s
 			// Generate buttons for each port
-			pCnt := strings.Count(s, "Port:")
 			var buttons [][]map[string]string
 			var row []map[string]string
 			inRow := calcRowLength(pCnt)
@@ -1349,7 +1602,8 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 			}
 			buttons = append(buttons, []map[string]string{
 				{ip: fmt.Sprintf("raw edit %s", ip)},
-				{"close": "close"},
+				exportButton(ctx, ip+" access"),
+				{tr(ctx, "close"): "close"},
 			})
 			kb = genKeyboard(buttons)
 		}
@@ -1358,10 +1612,10 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 	case "log":
 		o, _ := splitArgs(args)
 		offset, _ := strconv.Atoi(o)
-		res += fmt.Sprintf("events [%d - %d]:", offset+1, offset+limit)
-		s, isLastPage, err := swLogs(ip, offset, limit)
+		res += trf(ctx, "events [%d - %d]:", offset+1, offset+limit)
+		s, isLastPage, err := swLogs(ctx, ip, offset, limit)
 		if err != nil {
-			res += fmt.Sprintf("\n<code>%s</code>", err.Error())
+			res += fmtErr(errText(ctx, err))
 		} else {
 			res += s
 			// first row with pagination
@@ -1369,7 +1623,8 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 			// second row
 			buttons = append(buttons, []map[string]string{
 				{ip: fmt.Sprintf("raw edit %s", ip)},
-				{"close": "close"},
+				exportButton(ctx, fmt.Sprintf("%s log %d", ip, offset)),
+				{tr(ctx, "close"): "close"},
 			})
 			kb = genKeyboard(buttons)
 		}
@@ -1378,23 +1633,24 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 	default:
 		if _, err := strconv.Atoi(action); err != nil {
 			// empty or invalid port - return full sw info
-			res, err = swSummary(ip, "full")
+			res, err = swSummary(ctx, ip, "full")
 			// logs are displayed even if switch is not available
 			if err == nil || err.Error() == "unavailable" {
 				buttons := [][]map[string]string{
 					{
-						{"switch log": fmt.Sprintf("raw edit %s log", ip)},
+						{tr(ctx, "switch log"): fmt.Sprintf("raw edit %s log", ip)},
+						exportButton(ctx, ip),
 					},
 					{
-						{"refresh": fmt.Sprintf("raw edit %s", ip)},
-						{"close": "close"},
+						{tr(ctx, "refresh"): fmt.Sprintf("raw edit %s refresh", ip)},
+						{tr(ctx, "close"): "close"},
 					},
 				}
 				// if switch is available - add free and access ports buttons
 				if err == nil {
 					buttons = append([][]map[string]string{{
-						{"free ports": fmt.Sprintf("raw edit %s free", ip)},
-						{"access ports": fmt.Sprintf("raw edit %s access", ip)},
+						{tr(ctx, "free ports"): fmt.Sprintf("raw edit %s free", ip)},
+						{tr(ctx, "access ports"): fmt.Sprintf("raw edit %s access", ip)},
 					}}, buttons...)
 				}
 				kb = genKeyboard(buttons)
@@ -1404,13 +1660,16 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 		// else - go next to port handler
 		port = action
 	}
+	if !can(ctx, "port") {
+		return tr(ctx, MsgNoPermission), kb
+	}
 	// port logs handler
 	if a, o := splitArgs(args); a == "log" {
 		offset, _ := strconv.Atoi(o)
-		res += fmt.Sprintf("events [%d - %d]:", offset+1, offset+limit)
-		s, isLastPage, err := portLogs(ip, port, offset, limit)
+		res += trf(ctx, "events [%d - %d]:", offset+1, offset+limit)
+		s, isLastPage, err := portLogs(ctx, ip, port, offset, limit)
 		if err != nil {
-			res += fmt.Sprintf("\n<code>%s</code>", err.Error())
+			res += fmtErr(errText(ctx, err))
 		} else {
 			res += s
 			// first row with pagination
@@ -1418,79 +1677,83 @@ func swHandler(ip string, args string) (string, tgbotapi.InlineKeyboardMarkup) {
 			// second row
 			buttons = append(buttons, []map[string]string{
 				{fmt.Sprintf("%s %s", ip, port): fmt.Sprintf("raw edit %s %s", ip, port)},
-				{"close": "close"},
+				exportButton(ctx, fmt.Sprintf("%s %s log %d", ip, port, offset)),
+				{tr(ctx, "close"): "close"},
 			})
 			kb = genKeyboard(buttons)
 		}
 		return res, kb
 	}
 	// for ports switch view is always short
-	res, err = swSummary(ip, "short")
+	res, err = swSummary(ctx, ip, "short")
 	// no need to check port if switch is unavailable
 	if err != nil {
 		return res, kb
 	}
 	// clear counters if needed
+	canClear := can(ctx, "port clear")
 	if strings.Contains(args, "clear") {
-		logDebug(fmt.Sprintf("[swHandler] Clear result: %s", portClear(ip, port)))
+		if canClear {
+			logDebug("[swHandler] Counters cleared", "ip", ip, "port", port, "result", portClear(ctx, ip, port))
+		} else {
+			res += fmtErr(tr(ctx, MsgNoPermission))
+		}
 	}
 	if strings.Contains(args, "full") {
 		idx = 1
 	}
 	// get port summary
-	p, err := portSummary(ip, port, pView[idx])
+	p, err := portSummary(ctx, ip, port, pView[idx])
 	if err != nil {
-		return fmtErr(err.Error()), kb
+		return fmtErr(errText(ctx, err)), kb
 	}
 	res += p
+	buttons := []map[string]string{
+		// inverted view for full/short button calculated as (1 - idx)
+		{tr(ctx, pView[1-idx]): fmt.Sprintf("raw edit %s %s %s", ip, port, pView[1-idx])},
+		{tr(ctx, "port log"): fmt.Sprintf("raw edit %s %s log", ip, port)},
+		exportButton(ctx, fmt.Sprintf("%s %s %s", ip, port, pView[idx])),
+	}
+	if canClear {
+		buttons = append(buttons, map[string]string{tr(ctx, "clear counters"): fmt.Sprintf("raw edit %s %s %s clear", ip, port, pView[idx])})
+	}
 	kb = genKeyboard([][]map[string]string{
+		buttons,
 		{
-			// inverted view for full/short button calculated as (1 - idx)
-			{pView[1-idx]: fmt.Sprintf("raw edit %s %s %s", ip, port, pView[1-idx])},
-			{"port log": fmt.Sprintf("raw edit %s %s log", ip, port)},
-			{"clear counters": fmt.Sprintf("raw edit %s %s %s clear", ip, port, pView[idx])},
-		},
-		{
-			{"refresh": fmt.Sprintf("raw edit %s %s %s", ip, port, pView[idx])},
-			{"repeat": fmt.Sprintf("raw send %s %s %s", ip, port, pView[idx])},
-			{"close": "close"},
+			{tr(ctx, "refresh"): fmt.Sprintf("raw edit %s %s %s refresh", ip, port, pView[idx])},
+			{tr(ctx, "repeat"): fmt.Sprintf("raw send %s %s %s", ip, port, pView[idx])},
+			{tr(ctx, "close"): "close"},
 		},
 	})
 	return res, kb
 }
 
 // ip calc handler
-func calcHandler(arg string) string {
+func calcHandler(ctx context.Context, arg string) string {
 	var res string
 	ip := fullIP(arg, false)
 	if ip == "" {
-		res = fmt.Sprintf("[calc] wrong ip: %s", arg)
+		res = trf(ctx, "[calc] wrong ip: %s", arg)
 	} else {
-		res = ipCalc(ip)
+		res = ipCalc(ctx, ip)
 	}
 	return res
 }
 
 // search mode handler
-func searchHandler(kw string, page int) (string, tgbotapi.InlineKeyboardMarkup) {
+func searchHandler(ctx context.Context, kw string, page int) (string, tgbotapi.InlineKeyboardMarkup) {
 	var res string                       // text message result
 	var kb tgbotapi.InlineKeyboardMarkup // inline keyboard markup
-	resp, err := requestAPI("POST", "/db/search", map[string]interface{}{"keyword": kw, "page": page, "per_page": 4})
+	result, err := getAPI().DBSearch(ctx, kw, page, 4)
 	if err != nil {
-		res = fmt.Sprintf("Search for '%s': %v", kw, err)
+		res = trf(ctx, "Search for '%s': %v", kw, err)
 	} else {
-		var result DBSearch
-		err = mapstructure.Decode(resp, &result)
-		if err != nil {
-			logError(fmt.Sprintf("[search] %v", err))
-		} else {
-			res = fmtObj(result, "search.tmpl")
-			// callback pagination
-			if result.Meta.Pages.Total > 1 {
-				kb = genKeyboard(append(
-					rowPagination(fmt.Sprintf("search edit %s", kw), page, result.Meta.Pages.Total),
-					[]map[string]string{{"close": "close"}}))
-			}
+		res = fmtObj(ctx, result, "search.tmpl")
+		// callback pagination
+		if result.Meta.Pages.Total > 1 {
+			kb = genKeyboard(append(
+				rowPagination(fmt.Sprintf("search edit %s", kw), page, result.Meta.Pages.Total),
+				[]map[string]string{{tr(ctx, "close"): "close"}}))
 		}
 	}
 	return res, kb
@@ -1499,13 +1762,11 @@ func searchHandler(kw string, page int) (string, tgbotapi.InlineKeyboardMarkup)
 // start user pinger
 func pingerStart(uid int64, host string) error {
 	// one user can ping one host at time
-	if _, exist := Pingers[uid]; exist {
-		pingerStop(uid)
-	}
-	logDebug(fmt.Sprintf("[ping] [%s] starting %s", Users[uid].Name, host))
+	pingerStop(uid, nil)
+	logDebug("[ping] Starting", append(userFields(uid), "ip", host)...)
 	p, err := ping.NewPinger(host)
 	if err != nil {
-		logError(fmt.Sprintf("[ping] [%s] [%s] %v", Users[uid].Name, host, err))
+		logError("[ping] Start failed", append(userFields(uid), "ip", host, "error", err)...)
 		return err
 	}
 	// start message
@@ -1534,7 +1795,10 @@ func pingerStart(uid int64, host string) error {
 			stats.PacketsSent, stats.PacketsRecv, stats.PacketLoss,
 			fmtRTT(stats.MinRtt), fmtRTT(stats.AvgRtt), fmtRTT(stats.MaxRtt), fmtRTT(stats.StdDevRtt))
 		// remove keyboard only if no new pinger is running
-		if _, exist := Pingers[uid]; exist {
+		PingersMu.Lock()
+		cur, exist := Pingers[uid]
+		PingersMu.Unlock()
+		if exist && cur != p {
 			sendTo(uid, res)
 		} else {
 			kb := tgbotapi.NewRemoveKeyboard(true)
@@ -1542,41 +1806,50 @@ func pingerStart(uid int64, host string) error {
 		}
 	}
 	// add pinger to global list
-	Pingers[uid] = *p
+	PingersMu.Lock()
+	Pingers[uid] = p
+	PingersMu.Unlock()
 	// run ping in goroutine
-	go p.Run()
+	PingersWG.Add(1)
+	go func() {
+		defer PingersWG.Done()
+		p.Run()
+	}()
 	return err
 }
 
-// stop user pinger
-func pingerStop(uid int64) {
-	// check if pinger exists and stop it
-	if _, exist := Pingers[uid]; exist {
-		p := Pingers[uid]
-		logDebug(fmt.Sprintf("[ping] [%s] stopping %s", Users[uid].Name, p.Addr()))
+// stop user pinger, mode of user data (if not nil) is restored to raw
+func pingerStop(uid int64, data *UserData) {
+	// check if pinger exists and remove it from global list
+	PingersMu.Lock()
+	p, exist := Pingers[uid]
+	delete(Pingers, uid)
+	PingersMu.Unlock()
+	if exist {
+		logDebug("[ping] Stopping", append(userFields(uid), "ip", p.Addr())...)
 		p.Stop()
-		// remove pinger from global list
-		delete(Pingers, uid)
-		// restore mode
-		Data[uid].Mode = "raw"
+		if data != nil {
+			data.Mode = "raw"
+		}
 	}
 }
 
 // ping mode handler
-func pingHandler(msg string, uid int64) string {
+func pingHandler(ctx context.Context, data *UserData, msg string) string {
+	uid := ctxUID(ctx)
 	var res string // text message result
 	if msg == "stop" {
-		pingerStop(uid)
+		pingerStop(uid, data)
 	} else {
 		if fullIP(msg, true) != "" {
-			Data[uid].Mode = "raw"
-			return fmtErr("Impossible to ping switch ip without violating network conception. Use raw mode for availability checks.")
+			data.Mode = "raw"
+			return fmtErr(tr(ctx, "Impossible to ping switch ip without violating network conception. Use raw mode for availability checks."))
 		} else if ip := fullIP(msg, false); ip != "" {
 			msg = ip
 		}
 		if err := pingerStart(uid, msg); err != nil {
 			res = fmtErr(err.Error())
-			Data[uid].Mode = "raw"
+			data.Mode = "raw"
 		}
 	}
 	return res
@@ -1584,196 +1857,45 @@ func pingHandler(msg string, uid int64) string {
 
 // MAIN APP
 func main() {
-	initConfig()
-	// serve telegram updates
-	for u := range initBot() {
-		// empty updates if user blocked or restarted bot
-		if u.FromChat() == nil {
-			logWarning("Empty update")
-			continue
-		}
-		uid := u.FromChat().ID
-		// for unauthorized users only start cmd is available
-		if !userIsAuthorized(uid) && uid != CFG.Admin {
-			if u.Message != nil && u.Message.Command() == "start" {
-				newUserHandler(u.SentFrom())
-			}
-			// skip any other updates from unauthorized users
-			continue
+	checkConfig := flag.Bool("check-config", false, "validate config and exit")
+	flag.Parse()
+	if *checkConfig {
+		if _, err := loadConfig(CFGFILE); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		// message updates
-		if u.Message != nil {
-			logInfo(fmt.Sprintf("[message] [%s] %s", Users[uid].Name, u.Message.Text))
-			var msg string                       // input message
-			var res string                       // output message
-			var kb tgbotapi.InlineKeyboardMarkup // output keyboard markup
-
-			// send dummy message (will be edited after processing)
-			tmpMsg, _ := sendTo(uid, "Waiting...")
-
-			cmd := u.Message.Command()
-			cmdArgs := u.Message.CommandArguments()
-			if cmd != "" {
-				msg = cmdArgs
-			} else {
-				msg = u.Message.Text
-			}
-
-			// workaround to remove orphan cancel button
-			if msg == "cancel" && Data[uid].Mode != "comment" {
-				logWarning("[orphan] cancel removed")
-				clearReplyKeyboard(uid)
-				goto SEND
-			}
-
-			// stop pinger outside pinger mode
-			if msg == "stop" && Data[uid].Mode != "ping" {
-				logWarning("[orphan] pinger stopped")
-				pingerStop(uid)
-				goto SEND
-			}
-
-			if cmd != "" {
-				// reset mode for each new command
-				Data[uid].Mode = ""
-				// clear TMP for non-interactive commands
-				if cmd != "comment" && cmd != "config" {
-					Data[uid].TMP = ""
-				}
-			}
-
-			// maintenance mode
-			if CFG.MaintenanceMode && uid != CFG.Admin {
-				res, kb = CFG.MaintenanceMsg, closeButton()
-				goto SEND
-			}
-
-			// cmd processing
-			switch cmd {
-			case "help":
-				res, kb = HELPUSER, closeButton()
-				goto SEND
-			case "admin":
-				if uid == CFG.Admin {
-					Data[uid].Mode = "admin"
-				} else {
-					res, kb = "You have no permissions to work in this mode", closeButton()
-					goto SEND
-				}
-			case "raw":
-				Data[uid].Mode = cmd
-			case "calc":
-				if msg != "" {
-					res, kb = calcHandler(msg), closeButton()
-				}
-				goto SEND
-			case "ping":
-				if msg != "" {
-					Data[uid].Mode = cmd
-				}
-			// no command
-			case "":
-				// skip
-			// wrong command
-			default:
-				// ignore
-				goto SEND
-			}
-
-			// mode processing
-			switch Data[uid].Mode {
-			case "admin":
-				res = adminHandler(msg)
-			case "ping":
-				res = pingHandler(msg, uid)
-			default: // default is raw mode
-				res, kb = rawHandler(msg)
-			}
-		SEND:
-			// edit dummy message with actual res
-			if res != "" {
-				if len(kb.InlineKeyboard) > 0 {
-					editTextAndKeyboard(&tmpMsg, res, kb)
-				} else {
-					editTextRemoveKeyboard(&tmpMsg, res)
-				}
-			} else {
-				// delete dummy message on empty res
-				Bot.Request(tgbotapi.NewDeleteMessage(uid, tmpMsg.MessageID))
-			}
-			// clear user input
-			if cmd != "start" {
-				Bot.Request(tgbotapi.NewDeleteMessage(uid, u.Message.MessageID))
-			}
-
-		} else if u.CallbackData() != "" { // callback updates
-			logInfo(fmt.Sprintf("[callback] [%s] %s", Users[uid].Name, u.CallbackData()))
-			// skip dummy button
-			if u.CallbackData() == "dummy" {
-				continue
-			}
-			msg := u.CallbackQuery.Message
-			var res string                       // output message
-			var kb tgbotapi.InlineKeyboardMarkup // output keyboard markup
-			mode, args := splitArgs(u.CallbackData())
-			action, rawCmd := splitArgs(args)
-
-			// send dummy message or edit existing
-			switch action {
-			case "send":
-				tmpMsg, _ := sendTo(uid, "Waiting...")
-				// update pointer for message to edit after getting result
-				msg = &tmpMsg
-			case "edit":
-				// hide existing keyboard while waiting
-				editKeyboard(msg, genKeyboard([][]map[string]string{{{"Waiting...": "dummy"}}}))
-			}
-
-			// maintenance mode
-			if CFG.MaintenanceMode && uid != CFG.Admin && mode != "close" {
-				mode = "maintenance"
-			}
-
-			switch mode {
-			case "raw":
-				res, kb = rawHandler(rawCmd)
-			case "search":
-				// cut last argument - page number and convert to int
-				kw, p := splitLast(rawCmd)
-				page, _ := strconv.Atoi(p)
-				res, kb = searchHandler(kw, page)
-			case "close":
-				// delete message on close button
-				msgDate := time.Unix(int64(msg.Date), 0)
-				if time.Since(msgDate) > time.Hour*48 {
-					logWarning("[close] Message is older than 48h")
-					res = "<b>Bot cannot delete messages older than 48 hours!</b> \n\n" +
-						"<i>This is is a telegram api limitation. You can delete this message manually.</i> \n\n" +
-						"<code>https://core.telegram.org/bots/api#deletemessage</code>"
-				} else {
-					_, err := Bot.Request(tgbotapi.NewDeleteMessage(uid, msg.MessageID))
-					if err != nil {
-						logError(fmt.Sprintf("[close] %v", err))
-						res = fmtErr(err.Error())
-					} else {
-						continue
-					}
-				}
-			case "maintenance":
-				res, kb = CFG.MaintenanceMsg, closeButton()
-			default:
-				logWarning(fmt.Sprintf("[callback] wrong mode: %s", mode))
-				goto CALLBACK
-			}
-
-			// edit message
-			if len(kb.InlineKeyboard) > 0 {
-				editTextAndKeyboard(msg, res, kb)
-			} else {
-				editTextRemoveKeyboard(msg, res)
+		fmt.Println("config is valid")
+		return
+	}
+	if _, err := initConfig(); err != nil {
+		os.Exit(1)
+	}
+	// stop on termination signals
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// root context for updates processing, api request deadlines are derived from it,
+	// cancelled on shutdown if updates are not finished in time
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// updates are processed concurrently for different users
+	Updates = NewDispatcher(ctx, handleUpdate)
+	// serve telegram updates until signal
+	updates := initBot()
+	// reload config and templates on file change
+	if err := watchConfig(sigCtx); err != nil {
+		logError("[watch] Start failed", "error", err)
+	}
+LOOP:
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				break LOOP
 			}
-		CALLBACK:
-			Bot.Request(tgbotapi.NewCallback(u.CallbackQuery.ID, "Done"))
+			Updates.Dispatch(u)
+		case <-sigCtx.Done():
+			break LOOP
 		}
 	}
+	shutdown(cancel)
 }
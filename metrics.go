@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics are exposed in prometheus text format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// metric - anything that can be written in exposition format
+type metric interface {
+	write(w io.Writer)
+}
+
+// escape label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// format label pairs, e.g. {mode="raw",type="message"}
+func fmtLabels(names []string, values []string, extra ...string) string {
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// format float value
+func fmtValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series key from label values
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec - counter with labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64  // key is series key
+	series map[string][]string // label values for series key
+}
+
+// NewCounterVec - create and register counter
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+	registerMetric(c)
+	return c
+}
+
+// Inc - increment counter for label values
+func (c *CounterVec) Inc(values ...string) {
+	key := seriesKey(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = values
+	}
+	c.values[key]++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, fmtLabels(c.labels, c.series[k]), fmtValue(c.values[k]))
+	}
+}
+
+// histogram series
+type histogram struct {
+	values []string // label values
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec - histogram with labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // upper bounds, sorted
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+// NewHistogramVec - create and register histogram
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	registerMetric(h)
+	return h
+}
+
+// Observe - add value for label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := seriesKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, fmtLabels(h.labels, s.values, "le", fmtValue(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, fmtLabels(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, fmtLabels(h.labels, s.values), fmtValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, fmtLabels(h.labels, s.values), s.count)
+	}
+}
+
+// GaugeFunc - gauge with value calculated on scrape
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc - create and register gauge
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	registerMetric(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, fmtValue(g.fn()))
+}
+
+// registered metrics in output order
+var (
+	metrics   []metric
+	metricsMu sync.Mutex
+)
+
+// add metric to registry
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = append(metrics, m)
+}
+
+// bot metrics
+var (
+	MetricUpdates = NewCounterVec("inkotools_bot_updates_total",
+		"Telegram updates processed by type and mode.", "type", "mode")
+	MetricAPIRequests = NewCounterVec("inkotools_bot_api_requests_total",
+		"Inkotools API requests by endpoint and status code.", "method", "endpoint", "code")
+	MetricAPILatency = NewHistogramVec("inkotools_bot_api_request_duration_seconds",
+		"Inkotools API request latency by endpoint.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "method", "endpoint")
+	MetricTemplateErrors = NewCounterVec("inkotools_bot_template_errors_total",
+		"Template render failures by template.", "template")
+	MetricMessageTooLong = NewCounterVec("inkotools_bot_message_too_long_total",
+		"Messages split into pages because of telegram length limit.", "action")
+	MetricPingers = NewGaugeFunc("inkotools_bot_active_pingers",
+		"Number of running pingers.", func() float64 {
+			PingersMu.Lock()
+			defer PingersMu.Unlock()
+			return float64(len(Pingers))
+		})
+	MetricAPIDown = NewGaugeFunc("inkotools_bot_api_down",
+		"1 if inkotools API circuit breaker is open.", func() float64 {
+			if api := getAPI(); api != nil && api.Breaker != nil {
+				if open, _ := api.Breaker.Open(); open {
+					return 1
+				}
+			}
+			return 0
+		})
+)
+
+// patterns for variable endpoint parts, ip addresses and numbers (ports, pages)
+var (
+	reEndpointIP  = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	reEndpointNum = regexp.MustCompile(`/\d+(/|$)`)
+)
+
+// endpoint label without variable parts to keep series number low
+func endpointLabel(endpoint string) string {
+	e := reEndpointIP.ReplaceAllString(endpoint, "{ip}")
+	// replace twice for consecutive numbers
+	for i := 0; i < 2; i++ {
+		e = reEndpointNum.ReplaceAllString(e, "/{n}$1")
+	}
+	return e
+}
+
+// api response hook, code is zero if request failed without response
+func apiObserve(method string, endpoint string, code int, latency time.Duration) {
+	status := strconv.Itoa(code)
+	if code == 0 {
+		status = "error"
+	}
+	e := endpointLabel(endpoint)
+	MetricAPIRequests.Inc(method, e, status)
+	MetricAPILatency.Observe(latency.Seconds(), method, e)
+}
+
+// metrics http handler
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	metricsMu.Lock()
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	metricsMu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IPShortcut - short ip expansion rule, e.g. 57.10 with prefix 192.168. is 192.168.57.10
+type IPShortcut struct {
+	Octets int    `yaml:"octets"` // number of octets in short form
+	Prefix string `yaml:"prefix"` // missing octets, e.g. 192.168.
+}
+
+// DefaultSwitchSubnets - default switch management subnets
+var DefaultSwitchSubnets = []string{
+	"192.168.47.0/24",
+	"192.168.49.0/24",
+	"192.168.57.0/24",
+	"192.168.58.0/23",
+	"192.168.60.0/24",
+}
+
+// DefaultIPShortcuts - default short ip expansion rules
+var DefaultIPShortcuts = []IPShortcut{{Octets: 2, Prefix: "192.168."}}
+
+// ip address classes
+const (
+	ipSwitch  = "switch"
+	ipClient  = "client"
+	ipUnknown = ""
+)
+
+// switch subnets from config or defaults
+func (c Config) switchSubnets() []string {
+	if len(c.SwitchSubnets) > 0 {
+		return c.SwitchSubnets
+	}
+	return DefaultSwitchSubnets
+}
+
+// short ip expansion rules from config or defaults
+func (c Config) ipShortcuts() []IPShortcut {
+	if len(c.IPShortcuts) > 0 {
+		return c.IPShortcuts
+	}
+	return DefaultIPShortcuts
+}
+
+// check expansion rule, prefix with missing octets must give full ip
+func (s IPShortcut) validate() error {
+	if s.Octets < 1 || s.Octets > 3 {
+		return fmt.Errorf("octets must be from 1 to 3, got %d", s.Octets)
+	}
+	parts := strings.Split(strings.TrimSuffix(s.Prefix, "."), ".")
+	if len(parts)+s.Octets != 4 {
+		return fmt.Errorf("prefix %q must have %d octet(s)", s.Prefix, 4-s.Octets)
+	}
+	for _, p := range parts {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 255 {
+			return fmt.Errorf("prefix %q is not valid", s.Prefix)
+		}
+	}
+	return nil
+}
+
+// check if ip is in one of subnets, invalid subnets are skipped
+func inSubnets(ip net.IP, subnets []string) bool {
+	for _, s := range subnets {
+		if _, n, err := net.ParseCIDR(s); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand short ip with first matching rule
+func expandIP(ip string, rules []IPShortcut) string {
+	octets := strings.Count(ip, ".") + 1
+	for _, r := range rules {
+		if r.Octets == octets {
+			return strings.TrimSuffix(r.Prefix, ".") + "." + ip
+		}
+	}
+	return ip
+}
+
+// get ip address class by configured subnets, empty client subnets mean any non-switch ip
+func ipClass(ip string) string {
+	cfg := getConfig()
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ipUnknown
+	case inSubnets(parsed, cfg.switchSubnets()):
+		return ipSwitch
+	case len(cfg.ClientSubnets) == 0 || inSubnets(parsed, cfg.ClientSubnets):
+		return ipClient
+	}
+	return ipUnknown
+}
+
+// expand short ip and check it, return empty string on invalid ip or non-switch ip for switch
+func fullIP(ip string, isSwitch bool) string {
+	ip = expandIP(ip, getConfig().ipShortcuts())
+	if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil || !strings.Contains(ip, ".") {
+		return ""
+	}
+	if isSwitch && ipClass(ip) != ipSwitch {
+		return ""
+	}
+	return ip
+}
+
+// validate network options
+func (c Config) validateNetwork() []string {
+	var e []string
+	for _, list := range []struct {
+		name    string
+		subnets []string
+	}{{"switch_subnets", c.SwitchSubnets}, {"client_subnets", c.ClientSubnets}} {
+		for _, s := range list.subnets {
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				e = append(e, fmt.Sprintf("%s: %q is not valid CIDR, e.g. 192.168.57.0/24", list.name, s))
+			}
+		}
+	}
+	for i, s := range c.IPShortcuts {
+		if err := s.validate(); err != nil {
+			e = append(e, fmt.Sprintf("ip_shortcuts[%d]: %v", i, err))
+		}
+	}
+	return e
+}
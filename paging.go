@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxMessageLength - telegram limit for message text
+const MaxMessageLength int = 4096
+
+// space reserved for page number in paginated messages
+const pageFooterLength int = 64
+
+// time to keep pages of long messages for navigation
+const pagesTTL time.Duration = 24 * time.Hour
+
+// ErrPagesExpired - pages of message are not stored anymore
+var ErrPagesExpired = errors.New("pages expired")
+
+// html tag and entity at start of text
+var (
+	reTagStart    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+	reEntityStart = regexp.MustCompile(`^&#?[a-zA-Z0-9]+;`)
+)
+
+// part of html text which can not be split
+type htmlToken struct {
+	text string
+	tag  string // tag name, empty for text
+	end  bool   // closing tag
+}
+
+// length of text for telegram limit, characters outside of basic plane take two units,
+// tags and entities are counted as is, so result is never less than real length
+func textLen(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r > 0xFFFF {
+			n++
+		}
+	}
+	return n
+}
+
+// split html text into tags, entities and single characters
+func tokenizeHTML(text string) []htmlToken {
+	var res []htmlToken
+	for len(text) > 0 {
+		if m := reTagStart.FindStringSubmatch(text); m != nil {
+			res = append(res, htmlToken{text: m[0], tag: strings.ToLower(m[2]), end: m[1] != ""})
+			text = text[len(m[0]):]
+			continue
+		}
+		if m := reEntityStart.FindString(text); m != "" {
+			res = append(res, htmlToken{text: m})
+			text = text[len(m):]
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text)
+		res = append(res, htmlToken{text: text[:size]})
+		text = text[size:]
+	}
+	return res
+}
+
+// closing tags for open tags, innermost first
+func closeTags(open []htmlToken) string {
+	var res string
+	for i := len(open) - 1; i >= 0; i-- {
+		res += "</" + open[i].tag + ">"
+	}
+	return res
+}
+
+// reopen tags in next part
+func openTags(open []htmlToken) string {
+	var res string
+	for _, t := range open {
+		res += t.text
+	}
+	return res
+}
+
+// split html text into parts not longer than limit, parts are cut at empty lines between template
+// blocks if possible, then at line ends and spaces, tags are never cut, tags open at cut point
+// are closed at the end of part and reopened in next part
+func splitHTML(text string, limit int) []string {
+	if textLen(text) <= limit {
+		return []string{text}
+	}
+	// cut point levels, higher is better
+	const (
+		cutAny = iota
+		cutSpace
+		cutLine
+		cutBlock
+	)
+	type cut struct {
+		pos  int         // index of first token of next part
+		size int         // part size without closing tags
+		open []htmlToken // tags open at cut point
+	}
+	tokens := tokenizeHTML(text)
+	var parts []string
+	var open []htmlToken
+	for i := 0; i < len(tokens); {
+		var cuts [cutBlock + 1]*cut
+		prefix := openTags(open)
+		size := textLen(prefix)
+		j := i
+		for ; j < len(tokens); j++ {
+			t := tokens[j]
+			// possible cut before line break or space
+			if j > i {
+				level := cutAny
+				switch {
+				case t.text == "\n" && tokens[j-1].text == "\n":
+					level = cutBlock
+				case t.text == "\n":
+					level = cutLine
+				case t.text == " ":
+					level = cutSpace
+				}
+				cuts[level] = &cut{pos: j, size: size, open: open}
+			}
+			next := open
+			if t.tag != "" && t.end {
+				// close innermost tag with the same name, unknown closing tags are kept as is
+				for k := len(open) - 1; k >= 0; k-- {
+					if open[k].tag == t.tag {
+						next = append(append([]htmlToken(nil), open[:k]...), open[k+1:]...)
+						break
+					}
+				}
+			} else if t.tag != "" {
+				next = append(append([]htmlToken(nil), open...), t)
+			}
+			if size+textLen(t.text)+textLen(closeTags(next)) > limit {
+				break
+			}
+			size += textLen(t.text)
+			open = next
+		}
+		if j == len(tokens) {
+			parts = append(parts, prefix+joinTokens(tokens[i:]))
+			break
+		}
+		// best cut in second half of part, any cut otherwise
+		c := cuts[cutAny]
+		for level := cutBlock; level > cutAny; level-- {
+			if cuts[level] != nil && cuts[level].size >= limit/2 {
+				c = cuts[level]
+				break
+			}
+		}
+		if c == nil {
+			if j == i {
+				// first token alone does not fit, e.g. tag with huge attributes, it is cut as text
+				head, tail := cutText(tokens[i].text, limit-size-textLen(closeTags(open)))
+				parts = append(parts, prefix+head+closeTags(open))
+				tokens[i] = htmlToken{text: tail}
+				continue
+			}
+			c = &cut{pos: j, open: open}
+		}
+		parts = append(parts, prefix+joinTokens(tokens[i:c.pos])+closeTags(c.open))
+		open = c.open
+		// line breaks at cut point are not needed in next part
+		i = c.pos
+		for i < len(tokens) && (tokens[i].text == "\n" || tokens[i].text == " ") {
+			i++
+		}
+	}
+	return parts
+}
+
+// cut text at rune boundary to fit length, at least one rune is taken to make progress
+func cutText(s string, length int) (string, string) {
+	n, pos := 0, 0
+	for _, r := range s {
+		l := textLen(string(r))
+		if n+l > length && pos > 0 {
+			break
+		}
+		n += l
+		pos += utf8.RuneLen(r)
+	}
+	return s[:pos], s[pos:]
+}
+
+// join tokens back to text
+func joinTokens(tokens []htmlToken) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// pages of long message
+type messagePages struct {
+	Pages []string                      // page texts
+	KB    tgbotapi.InlineKeyboardMarkup // message keyboard without pagination
+	Lang  string                        // language for page number
+	Time  time.Time                     // last page update
+}
+
+// pages of long messages, key is chat and message id
+var (
+	Pages   = make(map[string]*messagePages)
+	PagesMu sync.Mutex
+)
+
+// split long text into pages and get first page with pagination keyboard
+func paginate(text string, kb tgbotapi.InlineKeyboardMarkup, lang string) *messagePages {
+	return &messagePages{
+		Pages: splitHTML(text, MaxMessageLength-pageFooterLength),
+		KB:    kb,
+		Lang:  lang,
+		Time:  time.Now(),
+	}
+}
+
+// page text with page number and keyboard with pagination row, pages are numbered from 1
+func (p *messagePages) view(page int) (string, tgbotapi.InlineKeyboardMarkup) {
+	if page < 1 {
+		page = 1
+	}
+	if page > len(p.Pages) {
+		page = len(p.Pages)
+	}
+	text := p.Pages[page-1] + fmt.Sprintf("\n\n<i>%s %d/%d</i>", translate(p.Lang, "Page"), page, len(p.Pages))
+	kb := genKeyboard(rowPagination("page edit", page, len(p.Pages)))
+	kb.InlineKeyboard = append(kb.InlineKeyboard, p.KB.InlineKeyboard...)
+	return text, kb
+}
+
+// save pages of sent or edited message, outdated pages are removed
+func savePages(m *tgbotapi.Message, p *messagePages) {
+	PagesMu.Lock()
+	defer PagesMu.Unlock()
+	for key, old := range Pages {
+		if time.Since(old.Time) > pagesTTL {
+			delete(Pages, key)
+		}
+	}
+	Pages[placeholderKey(m)] = p
+}
+
+// forget pages of message replaced with short text
+func dropPages(m *tgbotapi.Message) {
+	PagesMu.Lock()
+	defer PagesMu.Unlock()
+	delete(Pages, placeholderKey(m))
+}
+
+// show page of long message
+func showPage(m *tgbotapi.Message, page int) error {
+	PagesMu.Lock()
+	p, ok := Pages[placeholderKey(m)]
+	if ok {
+		p.Time = time.Now()
+	}
+	PagesMu.Unlock()
+	if !ok {
+		return ErrPagesExpired
+	}
+	text, kb := p.view(page)
+	return sendEdit(m, text, kb)
+}
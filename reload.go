@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// delay before reload, editors write files in several steps
+const reloadDelay time.Duration = time.Second
+
+// files written by bot itself, key is file name
+var (
+	selfWrites   = make(map[string]time.Time)
+	selfWritesMu sync.Mutex
+)
+
+// remember file written by bot
+func markSelfWrite(filename string) {
+	selfWritesMu.Lock()
+	defer selfWritesMu.Unlock()
+	selfWrites[filepath.Clean(filename)] = time.Now()
+}
+
+// check if file was recently written by bot
+func isSelfWrite(filename string) bool {
+	selfWritesMu.Lock()
+	defer selfWritesMu.Unlock()
+	t, ok := selfWrites[filepath.Clean(filename)]
+	return ok && time.Since(t) < reloadDelay*2
+}
+
+// load user configs from config directory, missing admin configs are created
+func loadUsers(cfg Config) (map[int64]*UserConfig, error) {
+	users := make(map[int64]*UserConfig)
+	files, err := os.ReadDir("config")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		uid, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".yml"), 10, 64)
+		if err != nil || f.IsDir() {
+			continue
+		}
+		logDebug("[init] Loading config file", "file", f.Name())
+		var u UserConfig
+		if err = readYML(&u, filepath.Join("config", f.Name())); err != nil {
+			return nil, err
+		}
+		if u.Timezone != "" {
+			if _, err = loadLocation(u.Timezone); err != nil {
+				logWarning("[config] Wrong user timezone, using main config timezone", "uid", uid, "error", err)
+			}
+		}
+		if u.Language != "" && parseLang(u.Language) == "" {
+			logWarning("[config] User language is not supported, using default", "uid", uid, "lang", u.Language)
+		}
+		users[uid] = &u
+	}
+	// init admin accounts
+	for _, id := range cfg.adminIDs() {
+		if _, ok := users[id]; !ok {
+			logWarning("[init] Creating admin config", "uid", id)
+			u := UserConfig{Name: "admin"}
+			if err = writeYML(&u, fmt.Sprintf("config/%d.yml", id)); err != nil {
+				return nil, err
+			}
+			users[id] = &u
+		}
+	}
+	return users, nil
+}
+
+// parse templates, templates from previous version must not disappear
+func loadTemplates(old *template.Template) (*template.Template, error) {
+	// template functions
+	funcMap := template.FuncMap{
+		"fmtBytes": fmtBytes,
+		"fmtKbits": func(x uint) string { return fmtBytes(x*125, true) },
+		"fmtState": func(b bool) string {
+			if b {
+				return "enabled"
+			}
+			return "disabled"
+		},
+		"fmtHTML": html.EscapeString,
+		"inc":     func(x int) int { return x + 1 },
+		"add":     func(x, y int) int { return x + y },
+		// default timezone, replaced with user timezone on render
+		"localTime": func(t time.Time) time.Time { return t.In(userLocation(0)) },
+		// deprecated alias of localTime for custom templates
+		"utc2msk": func(t time.Time) time.Time { return t.In(userLocation(0)) },
+		// default language, replaced with user language on render
+		"tr": func(s string) string { return translate(pickLang(""), s) },
+	}
+	tpl, err := template.New("templates").Funcs(funcMap).ParseGlob("templates/*")
+	if err != nil {
+		return nil, fmt.Errorf("parse failed: %v", err)
+	}
+	if old != nil {
+		for _, t := range old.Templates() {
+			if t.Tree != nil && tpl.Lookup(t.Name()) == nil {
+				return nil, fmt.Errorf("template %s is missing", t.Name())
+			}
+		}
+	}
+	for _, t := range tpl.Templates() {
+		logDebug("[template] Loaded", "template", t.Name())
+	}
+	return tpl, nil
+}
+
+// check if api client settings are changed
+func apiConfigChanged(a Config, b Config) bool {
+	return a.InkoToolsAPI != b.InkoToolsAPI ||
+		a.APITimeout != b.APITimeout ||
+		a.APISlowTimeout != b.APISlowTimeout ||
+		a.APIRetries != b.APIRetries ||
+		a.APIDownAfter != b.APIDownAfter ||
+		a.APIDownCooldown != b.APIDownCooldown ||
+		!reflect.DeepEqual(a.APICache, b.APICache)
+}
+
+// template source by name, sub-templates without tree are skipped
+func templateSources(tpl *template.Template) map[string]string {
+	res := make(map[string]string)
+	if tpl == nil {
+		return res
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			res[t.Name()] = t.Tree.Root.String()
+		}
+	}
+	return res
+}
+
+// summary of changes between config versions, empty if nothing changed,
+// only names of main config options are listed to keep secrets out of chat
+func configDiff(oldCfg Config, newCfg Config, oldUsers map[int64]*UserConfig,
+	newUsers map[int64]*UserConfig, oldTpl *template.Template, newTpl *template.Template) string {
+	var res []string
+	// main config options
+	var fields []string
+	vo, vn := reflect.ValueOf(oldCfg), reflect.ValueOf(newCfg)
+	for i := 0; i < vo.NumField(); i++ {
+		if !reflect.DeepEqual(vo.Field(i).Interface(), vn.Field(i).Interface()) {
+			fields = append(fields, strings.Split(vo.Type().Field(i).Tag.Get("yaml"), ",")[0])
+		}
+	}
+	if len(fields) > 0 {
+		res = append(res, fmt.Sprintf("<b>config:</b> %s", strings.Join(fields, ", ")))
+	}
+	// users
+	var added, removed, changed []string
+	for uid, u := range newUsers {
+		o, ok := oldUsers[uid]
+		switch {
+		case !ok:
+			added = append(added, fmt.Sprintf("%s (%d)", html.EscapeString(u.Name), uid))
+		case *o != *u:
+			changed = append(changed, fmt.Sprintf("%s (%d)", html.EscapeString(u.Name), uid))
+		}
+	}
+	for uid, u := range oldUsers {
+		if _, ok := newUsers[uid]; !ok {
+			removed = append(removed, fmt.Sprintf("%s (%d)", html.EscapeString(u.Name), uid))
+		}
+	}
+	for _, l := range []struct {
+		name  string
+		items []string
+	}{{"users added", added}, {"users removed", removed}, {"users changed", changed}} {
+		if len(l.items) > 0 {
+			sort.Strings(l.items)
+			res = append(res, fmt.Sprintf("<b>%s:</b> %s", l.name, strings.Join(l.items, ", ")))
+		}
+	}
+	// templates
+	var tplChanged []string
+	oldSrc, newSrc := templateSources(oldTpl), templateSources(newTpl)
+	for name, src := range newSrc {
+		if oldSrc[name] != src {
+			tplChanged = append(tplChanged, name)
+		}
+	}
+	if len(tplChanged) > 0 && oldTpl != nil {
+		sort.Strings(tplChanged)
+		res = append(res, fmt.Sprintf("<b>templates:</b> %s", strings.Join(tplChanged, ", ")))
+	}
+	return strings.Join(res, "\n")
+}
+
+// check if file change should trigger reload
+func watchedFile(name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp") {
+		return false
+	}
+	if filepath.Dir(name) == "config" {
+		return strings.HasSuffix(base, ".yml")
+	}
+	return true
+}
+
+// reload config after file change and notify admins
+func autoReload() {
+	logInfo("[watch] Files changed, reloading config")
+	diff, err := initConfig()
+	if err != nil {
+		notifyAdminsf(0, "Config reload failed, old config is kept:%s", fmtErr(html.EscapeString(err.Error())))
+		return
+	}
+	if diff != "" {
+		logInfo("[watch] Config reloaded")
+		notifyAdminsf(0, "Config reloaded:\n%s", diff)
+	}
+}
+
+// watch config and templates directories until context is done
+func watchConfig(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"config", "templates"} {
+		if err = w.Add(dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go func() {
+		defer w.Close()
+		// several changes in short time trigger one reload
+		var timer *time.Timer
+		reload := make(chan struct{}, 1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Op == fsnotify.Chmod || !watchedFile(e.Name) || isSelfWrite(e.Name) {
+					continue
+				}
+				logDebug("[watch] File changed", "file", e.Name, "op", e.Op.String())
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					select {
+					case reload <- struct{}{}:
+					default:
+					}
+				})
+			case <-reload:
+				autoReload()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logError("[watch] Watch failed", "error", err)
+			}
+		}
+	}()
+	logInfo("[init] Watching config and templates for changes")
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Role - user access level
+type Role int
+
+// user roles, each next role has all permissions of previous ones
+const (
+	RoleViewer   Role = iota // can view switches and ports
+	RoleEngineer             // can change port state and notify users
+	RoleAdmin                // can manage users and bot
+)
+
+// DefaultRole - role for users without role in config and new users,
+// higher roles are granted explicitly with admin role command
+const DefaultRole = RoleViewer
+
+// MsgNoPermission - permission denied message
+const MsgNoPermission string = "You have no permissions for this action"
+
+// role names in user config
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleEngineer: "engineer",
+	RoleAdmin:    "admin",
+}
+
+// String - role name
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// parse role name, empty name is default role
+func parseRole(name string) (Role, error) {
+	if name == "" {
+		return DefaultRole, nil
+	}
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return DefaultRole, fmt.Errorf("unknown role: %s", name)
+}
+
+// Permissions - minimal role for each action checked with can, actions not in table
+// are allowed only for admin, routes without permission are allowed for all users
+var Permissions = map[string]Role{
+	"switch":            RoleViewer,   // switch summary, logs, free and access ports
+	"port":              RoleViewer,   // port summary and logs
+	"port clear":        RoleEngineer, // clear port counters
+	"search":            RoleViewer,   // search switches in database
+	"calc":              RoleViewer,   // ip calculator
+	"ping":              RoleViewer,   // ping mode
+	"history":           RoleViewer,   // user requests history
+	"export":            RoleViewer,   // export switch and port views as file
+	"admin":             RoleEngineer, // enter admin mode
+	"admin list":        RoleEngineer, // list authorized users
+	"admin send":        RoleEngineer, // send message to user
+	"admin broadcast":   RoleEngineer, // send message to all users
+	"admin add":         RoleAdmin,    // add user
+	"admin del":         RoleAdmin,    // delete user
+	"admin role":        RoleAdmin,    // change user role
+	"admin reload":      RoleAdmin,    // reload configuration
+	"admin maintenance": RoleAdmin,    // toggle maintenance mode
+	"admin cache":       RoleAdmin,    // api cache stats and flush
+	"admin audit":       RoleAdmin,    // audit log queries
+	"admin log":         RoleAdmin,    // runtime log level
+	"user approve":      RoleAdmin,    // approve access request
+	"user deny":         RoleAdmin,    // deny access request
+}
+
+// get user role, admins from main config are always admins
+func userRole(uid int64) Role {
+	if int64InList(uid, getConfig().adminIDs()) {
+		return RoleAdmin
+	}
+	UsersMu.RLock()
+	defer UsersMu.RUnlock()
+	if u, ok := Users[uid]; ok {
+		if r, err := parseRole(u.Role); err == nil {
+			return r
+		}
+	}
+	return RoleViewer
+}
+
+// check if user is allowed to perform action
+func userCan(uid int64, action string) bool {
+	min, ok := Permissions[action]
+	if !ok {
+		min = RoleAdmin
+	}
+	return userRole(uid) >= min
+}
+
+// check if user is admin
+func isAdmin(uid int64) bool {
+	return userRole(uid) == RoleAdmin
+}
+
+// get ids of admins from main config and users with admin role, main config admins first
+func adminIDs() []int64 {
+	ids := getConfig().adminIDs()
+	users := userIDs()
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	for _, id := range users {
+		if !int64InList(id, ids) && isAdmin(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// context key type
+type ctxKey int
+
+// context key for uid of user who sent update
+const uidKey ctxKey = iota
+
+// add uid to update processing context
+func withUID(ctx context.Context, uid int64) context.Context {
+	return context.WithValue(ctx, uidKey, uid)
+}
+
+// get uid from update processing context
+func ctxUID(ctx context.Context) int64 {
+	uid, _ := ctx.Value(uidKey).(int64)
+	return uid
+}
+
+// check if user from context is allowed to perform action
+func can(ctx context.Context, action string) bool {
+	return userCan(ctxUID(ctx), action)
+}
+
+// set user role and save user config
+func setUserRole(ctx context.Context, args string) string {
+	u, name := splitArgs(args)
+	uid, err := strconv.ParseInt(u, 10, 64)
+	if err != nil || !userIsAuthorized(uid) {
+		return fmtErr(tr(ctx, "Wrong uid"))
+	}
+	role, err := parseRole(name)
+	if err != nil || name == "" {
+		return fmtErr(trf(ctx, "Wrong role, use one of: %s, %s, %s", RoleViewer, RoleEngineer, RoleAdmin))
+	}
+	UsersMu.Lock()
+	Users[uid].Role = role.String()
+	UsersMu.Unlock()
+	if err = saveUserConfig(uid); err != nil {
+		return fmtErr(err.Error())
+	}
+	logInfo("[user] Role set", append(userFields(uid), "role", role)...)
+	return trf(ctx, "User <code>%d</code> <b>%s</b> role: <code>%s</code>", uid, userName(uid), role)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultMode - text mode for messages without command and for unknown modes
+const DefaultMode string = "raw"
+
+// Request - routed message or callback, user is taken from context
+type Request struct {
+	Data   *UserData         // user data, saved after update if changed
+	Action string            // callback action after prefix, e.g. send or edit
+	Args   string            // command arguments, message text or callback arguments after action
+	Msg    *tgbotapi.Message // message to edit for callbacks
+}
+
+// Reply - result of route handler
+type Reply struct {
+	Text  string                        // output message, empty message is deleted
+	KB    tgbotapi.InlineKeyboardMarkup // output keyboard markup
+	Keep  bool                          // callback message is already updated by handler or must be kept as is
+	Alert string                        // callback answer shown as alert instead of Done
+}
+
+// RouteHandler - function to process routed request
+type RouteHandler func(ctx context.Context, r *Request) Reply
+
+// RouteArg - argument of command schema
+type RouteArg struct {
+	Name     string // name for usage, e.g. IP
+	Optional bool   // optional arguments are not checked
+}
+
+// RouteUsage - help line for text mode
+type RouteUsage struct {
+	Args        string // input example, e.g. SW_IP PORT
+	Description string // english description, translated in help
+}
+
+// Route - handler of command, text mode or callback prefix with metadata
+type Route struct {
+	Name        string       // command, mode or callback prefix
+	Description string       // english description for commands menu and help
+	Args        []RouteArg   // argument schema of command, request without required arguments gets usage
+	Usage       []RouteUsage // help lines of text mode
+	Permission  string       // action from Permissions required for route, empty if allowed for all users
+	Menu        bool         // show command in telegram commands menu
+	Hidden      bool         // do not show command in help
+	Mode        string       // command switches user to text mode, arguments are processed in this mode
+	Maintenance bool         // callback is available in maintenance mode
+	Handler     RouteHandler
+}
+
+// check if user from context is allowed to use route
+func (r *Route) allowed(ctx context.Context) bool {
+	if r.Permission == "" {
+		return true
+	}
+	return can(ctx, r.Permission)
+}
+
+// command usage generated from argument schema
+func (r *Route) usage() string {
+	res := "/" + r.Name
+	for _, a := range r.Args {
+		if a.Optional {
+			res += " [" + a.Name + "]"
+		} else {
+			res += " " + a.Name
+		}
+	}
+	return res
+}
+
+// check if all required arguments are present
+func (r *Route) checkArgs(args string) bool {
+	required := 0
+	for _, a := range r.Args {
+		if !a.Optional {
+			required++
+		}
+	}
+	return len(strings.Fields(args)) >= required
+}
+
+// RouteTable - routes by name in registration order
+type RouteTable struct {
+	names  []string
+	routes map[string]*Route
+}
+
+// NewRouteTable - create empty route table
+func NewRouteTable() *RouteTable {
+	return &RouteTable{routes: make(map[string]*Route)}
+}
+
+// Register - add route to table, route with the same name is replaced
+func (t *RouteTable) Register(r Route) {
+	if _, ok := t.routes[r.Name]; !ok {
+		t.names = append(t.names, r.Name)
+	}
+	t.routes[r.Name] = &r
+}
+
+// Get - get route by name
+func (t *RouteTable) Get(name string) (*Route, bool) {
+	r, ok := t.routes[name]
+	return r, ok
+}
+
+// List - get routes in registration order
+func (t *RouteTable) List() []*Route {
+	res := make([]*Route, len(t.names))
+	for i, name := range t.names {
+		res[i] = t.routes[name]
+	}
+	return res
+}
+
+// registered routes, built-in ones are in routes.go
+var (
+	Commands  = NewRouteTable() // bot commands, e.g. /ping
+	Modes     = NewRouteTable() // text modes for messages without command
+	Callbacks = NewRouteTable() // callback data prefixes
+)
+
+// help for user generated from registered modes and commands available for user
+func userHelp(ctx context.Context) string {
+	res := "\n"
+	for _, r := range Modes.List() {
+		if !r.allowed(ctx) {
+			continue
+		}
+		for _, u := range r.Usage {
+			res += fmt.Sprintf("<code>%s</code> - %s\n", u.Args, tr(ctx, u.Description))
+		}
+	}
+	for _, r := range Commands.List() {
+		if r.Hidden || !r.allowed(ctx) {
+			continue
+		}
+		res += fmt.Sprintf("<code>%s</code> - %s\n", r.usage(), tr(ctx, r.Description))
+	}
+	return res + "\n"
+}
+
+// bot commands menu translated to language
+func botCommands(lang string) []tgbotapi.BotCommand {
+	var res []tgbotapi.BotCommand
+	for _, r := range Commands.List() {
+		if r.Menu {
+			res = append(res, tgbotapi.BotCommand{Command: r.Name, Description: translate(lang, r.Description)})
+		}
+	}
+	return res
+}
+
+// reply with message for users without permission
+func noPermission(ctx context.Context) Reply {
+	return Reply{Text: tr(ctx, MsgNoPermission), KB: closeButton(ctx)}
+}
+
+// reply with maintenance message
+func maintenance(ctx context.Context) Reply {
+	return Reply{Text: tr(ctx, getConfig().MaintenanceMsg), KB: closeButton(ctx)}
+}
+
+// find and run handler for message command or text mode
+func routeMessage(ctx context.Context, data *UserData, cmd string, msg string) Reply {
+	uid := ctxUID(ctx)
+	// workaround to remove orphan cancel button
+	if msg == "cancel" && data.Mode != "comment" {
+		logWarning("[orphan] cancel removed")
+		clearReplyKeyboard(uid)
+		return Reply{}
+	}
+	// stop pinger outside pinger mode
+	if msg == "stop" && data.Mode != "ping" {
+		logWarning("[orphan] pinger stopped")
+		pingerStop(uid, data)
+		return Reply{}
+	}
+	if cmd != "" {
+		// reset mode and TMP for each new command
+		data.Mode, data.TMP = "", ""
+	}
+	if getConfig().MaintenanceMode && !isAdmin(uid) {
+		return maintenance(ctx)
+	}
+	req := &Request{Data: data, Args: msg}
+	if cmd != "" {
+		r, ok := Commands.Get(cmd)
+		if !ok {
+			// wrong command is ignored
+			return Reply{}
+		}
+		if !r.allowed(ctx) {
+			return noPermission(ctx)
+		}
+		if !r.checkArgs(msg) {
+			return Reply{Text: trf(ctx, "Usage: <code>%s</code>", r.usage()), KB: closeButton(ctx)}
+		}
+		if r.Mode == "" {
+			return r.Handler(ctx, req)
+		}
+		data.Mode = r.Mode
+	}
+	r, ok := Modes.Get(data.Mode)
+	if !ok {
+		r, _ = Modes.Get(DefaultMode)
+	}
+	if !r.allowed(ctx) {
+		return noPermission(ctx)
+	}
+	return r.Handler(ctx, req)
+}
+
+// find and run handler for callback prefix
+func routeCallback(ctx context.Context, name string, req *Request) Reply {
+	r, ok := Callbacks.Get(name)
+	if !ok {
+		logWarning("[callback] Wrong mode", "mode", name)
+		return Reply{Keep: true}
+	}
+	if getConfig().MaintenanceMode && !isAdmin(ctxUID(ctx)) && !r.Maintenance {
+		return maintenance(ctx)
+	}
+	if !r.allowed(ctx) {
+		return noPermission(ctx)
+	}
+	return r.Handler(ctx, req)
+}
+
+// process message update
+func handleMessage(ctx context.Context, u tgbotapi.Update, data *UserData) {
+	uid := ctxUID(ctx)
+	logInfo("[message] Received", append(userFields(uid), "text", u.Message.Text)...)
+	// send dummy message (will be edited after processing)
+	tmpMsg, _ := sendTo(uid, tr(ctx, "Waiting..."))
+	addPlaceholder(&tmpMsg, true)
+	defer donePlaceholder(&tmpMsg)
+
+	cmd := u.Message.Command()
+	msg := u.Message.Text
+	if cmd != "" {
+		msg = u.Message.CommandArguments()
+	}
+	var res Reply
+	defer func() {
+		audit(ctx, AuditEntry{Kind: "message", Mode: data.Mode, Action: cmd, Args: msg, Result: auditResult(res.Text)})
+		MetricUpdates.Inc("message", data.Mode)
+	}()
+
+	res = routeMessage(ctx, data, cmd, msg)
+	// update is cancelled on shutdown
+	if ctx.Err() != nil {
+		res = Reply{Text: tr(ctx, MsgRestarting), KB: closeButton(ctx)}
+	}
+	// edit dummy message with actual result
+	switch {
+	case res.Text == "":
+		// delete dummy message on empty result
+		Telegram.Request(tgbotapi.NewDeleteMessage(uid, tmpMsg.MessageID))
+	case len(res.KB.InlineKeyboard) > 0:
+		editTextAndKeyboard(&tmpMsg, res.Text, res.KB)
+	default:
+		editTextRemoveKeyboard(&tmpMsg, res.Text)
+	}
+	// clear user input
+	if cmd != "start" {
+		Telegram.Request(tgbotapi.NewDeleteMessage(uid, u.Message.MessageID))
+	}
+}
+
+// process callback update, callback data is already decoded
+func handleCallback(ctx context.Context, u tgbotapi.Update, data *UserData, callback string, callbackErr error) {
+	uid := ctxUID(ctx)
+	logInfo("[callback] Received", append(userFields(uid), "data", callback)...)
+	// buttons from chat history may be expired
+	if callbackErr != nil {
+		logWarning("[callback] Decode failed", append(userFields(uid), "error", callbackErr, "data", u.CallbackData())...)
+		MetricUpdates.Inc("callback", "expired")
+		Telegram.Request(tgbotapi.NewCallbackWithAlert(u.CallbackQuery.ID, tr(ctx, MsgButtonExpired)))
+		return
+	}
+	// skip dummy button
+	if callback == "dummy" {
+		return
+	}
+	name, args := splitArgs(callback)
+	action, other := splitArgs(args)
+	req := &Request{Data: data, Action: action, Args: other, Msg: u.CallbackQuery.Message}
+	var res Reply
+	defer func() {
+		result := res.Text
+		if res.Alert != "" {
+			result = res.Alert
+		}
+		audit(ctx, AuditEntry{Kind: "callback", Mode: name, Action: action, Args: other, Result: auditResult(result)})
+		MetricUpdates.Inc("callback", name)
+	}()
+
+	// send dummy message or edit existing
+	switch action {
+	case "send":
+		tmpMsg, _ := sendTo(uid, tr(ctx, "Waiting..."))
+		// update pointer for message to edit after getting result
+		req.Msg = &tmpMsg
+		addPlaceholder(req.Msg, true)
+		defer donePlaceholder(req.Msg)
+	case "edit":
+		// hide existing keyboard while waiting
+		editKeyboard(req.Msg, genKeyboard([][]map[string]string{{{tr(ctx, "Waiting..."): "dummy"}}}))
+		addPlaceholder(req.Msg, false)
+		defer donePlaceholder(req.Msg)
+	}
+
+	res = routeCallback(ctx, name, req)
+	if !res.Keep {
+		// update is cancelled on shutdown
+		if ctx.Err() != nil {
+			res = Reply{Text: tr(ctx, MsgRestarting), KB: closeButton(ctx)}
+		}
+		if len(res.KB.InlineKeyboard) > 0 {
+			editTextAndKeyboard(req.Msg, res.Text, res.KB)
+		} else {
+			editTextRemoveKeyboard(req.Msg, res.Text)
+		}
+	}
+	if res.Alert != "" {
+		Telegram.Request(tgbotapi.NewCallbackWithAlert(u.CallbackQuery.ID, res.Alert))
+		return
+	}
+	Telegram.Request(tgbotapi.NewCallback(u.CallbackQuery.ID, tr(ctx, "Done")))
+}
+
+// process single telegram update
+func handleUpdate(ctx context.Context, u tgbotapi.Update) {
+	// empty updates if user blocked or restarted bot
+	if u.FromChat() == nil {
+		logWarning("[update] Empty update")
+		return
+	}
+	uid := u.FromChat().ID
+	cfg := getConfig()
+	// long callback payloads are stored on server behind tokens
+	callback, callbackErr := decodeCallback(u.CallbackData())
+	// in admin group only access request buttons are processed, for admin who pressed button
+	if cfg.AdminGroup != 0 && uid == cfg.AdminGroup {
+		if !strings.HasPrefix(callback, "user ") {
+			return
+		}
+		uid = u.SentFrom().ID
+	}
+	ctx = withUID(ctx, uid)
+	// for unauthorized users only start cmd is available
+	if !userIsAuthorized(uid) && !int64InList(uid, cfg.adminIDs()) {
+		// access request button in admin group is answered to member who pressed it
+		if u.CallbackQuery != nil && cfg.AdminGroup != 0 && u.FromChat().ID == cfg.AdminGroup {
+			lang := pickLang(u.SentFrom().LanguageCode)
+			Telegram.Request(tgbotapi.NewCallbackWithAlert(u.CallbackQuery.ID, translate(lang, MsgNoPermission)))
+			return
+		}
+		if u.Message != nil && u.Message.Command() == "start" {
+			audit(ctx, AuditEntry{Kind: "message", Action: "start", Result: "request"})
+			MetricUpdates.Inc("message", "start")
+			newUserHandler(u.SentFrom())
+		}
+		// skip any other updates from unauthorized users
+		return
+	}
+	data := getUserData(uid)
+	// messages sent outside of updates use last known language
+	setUserLang(uid, u.SentFrom().LanguageCode)
+	// save user data if it was changed while processing update
+	before := data.copy()
+	defer func() {
+		if !reflect.DeepEqual(before, data.copy()) && userIsAuthorized(uid) {
+			saveUserData(uid, data)
+		}
+	}()
+	if u.Message != nil {
+		handleMessage(ctx, u, data)
+	} else if u.CallbackData() != "" {
+		handleCallback(ctx, u, data, callback, callbackErr)
+	}
+}
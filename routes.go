@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// built-in routes, order of registration is order in help and commands menu
+func init() {
+	// commands
+	Commands.Register(Route{
+		Name:        "help",
+		Description: "print help",
+		Menu:        true,
+		Hidden:      true,
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: userHelp(ctx), KB: closeButton(ctx)}
+		},
+	})
+	Commands.Register(Route{
+		Name:        "ping",
+		Description: "ping",
+		Args:        []RouteArg{{Name: "IP"}},
+		Permission:  "ping",
+		Mode:        "ping",
+	})
+	Commands.Register(Route{
+		Name:        "calc",
+		Description: "ip calc",
+		Args:        []RouteArg{{Name: "IP"}},
+		Permission:  "calc",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: calcHandler(ctx, r.Args), KB: closeButton(ctx)}
+		},
+	})
+	Commands.Register(Route{
+		Name:        "history",
+		Description: "last requests",
+		Permission:  "history",
+		Menu:        true,
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: historyHandler(ctx, r.Data), KB: closeButton(ctx)}
+		},
+	})
+	Commands.Register(Route{
+		Name:        "admin",
+		Description: "admin mode",
+		Args:        []RouteArg{{Name: "CMD", Optional: true}},
+		Permission:  "admin",
+		Hidden:      true,
+		Mode:        "admin",
+	})
+	Commands.Register(Route{
+		Name:        "raw",
+		Description: "switch info mode",
+		Args:        []RouteArg{{Name: "SW_IP", Optional: true}},
+		Hidden:      true,
+		Mode:        "raw",
+	})
+
+	// text modes
+	Modes.Register(Route{
+		Name: "raw",
+		Usage: []RouteUsage{
+			{Args: "SW_IP", Description: "get switch summary"},
+			{Args: "SW_IP PORT", Description: "get port info"},
+			{Args: "SW_IP free", Description: "get free ports"},
+		},
+		Handler: func(ctx context.Context, r *Request) Reply {
+			text, kb := rawHandler(ctx, withPortView(r.Args, r.Data.PortView))
+			if r.Args != "" {
+				r.Data.addHistory(r.Args)
+				r.Data.trackView(r.Args)
+			}
+			return Reply{Text: text, KB: kb}
+		},
+	})
+	Modes.Register(Route{
+		Name:       "admin",
+		Permission: "admin",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: adminHandler(ctx, r.Args)}
+		},
+	})
+	Modes.Register(Route{
+		Name:       "ping",
+		Permission: "ping",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: pingHandler(ctx, r.Data, r.Args)}
+		},
+	})
+	Modes.Register(Route{
+		Name: "comment",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			return Reply{Text: commentHandler(ctx, r.Data, r.Args)}
+		},
+	})
+
+	// callbacks
+	Callbacks.Register(Route{
+		Name: "raw",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			text, kb := rawHandler(ctx, r.Args)
+			r.Data.trackView(r.Args)
+			return Reply{Text: text, KB: kb}
+		},
+	})
+	Callbacks.Register(Route{
+		Name:       "search",
+		Permission: "search",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			// cut last argument - page number and convert to int
+			kw, p := splitLast(r.Args)
+			page, _ := strconv.Atoi(p)
+			text, kb := searchHandler(ctx, kw, page)
+			return Reply{Text: text, KB: kb}
+		},
+	})
+	Callbacks.Register(Route{
+		Name:        "close",
+		Maintenance: true,
+		Handler:     closeHandler,
+	})
+	Callbacks.Register(Route{
+		Name: "page",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			// pages of long message are kept in memory, they are lost on restart
+			page, _ := strconv.Atoi(r.Args)
+			err := showPage(r.Msg, page)
+			if err == nil {
+				return Reply{Keep: true}
+			}
+			if err == ErrPagesExpired {
+				return Reply{Text: tr(ctx, "Pages are expired, repeat the request"), KB: closeButton(ctx)}
+			}
+			return Reply{Text: fmtErr(err.Error()), KB: closeButton(ctx)}
+		},
+	})
+	Callbacks.Register(Route{
+		Name:       "export",
+		Permission: "export",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			// file is sent as new message, view message is kept
+			exportHandler(ctx, r.Msg, r.Action, r.Args)
+			return Reply{Keep: true}
+		},
+	})
+	Callbacks.Register(Route{
+		Name: "user",
+		Handler: func(ctx context.Context, r *Request) Reply {
+			// request message is kept for other admins, denial is shown to user who pressed button
+			if !can(ctx, "user "+r.Action) {
+				return Reply{Keep: true, Alert: tr(ctx, MsgNoPermission)}
+			}
+			res := accessHandler(ctx, r.Data, r.Msg, r.Action+" "+r.Args)
+			// request message is edited later
+			return Reply{Text: res, Keep: res == ""}
+		},
+	})
+}
+
+// close button handler, deletes message
+func closeHandler(ctx context.Context, r *Request) Reply {
+	msgDate := time.Unix(int64(r.Msg.Date), 0)
+	if time.Since(msgDate) > time.Hour*48 {
+		logWarning("[close] Message is older than 48h")
+		return Reply{Text: tr(ctx, MsgCannotDelete)}
+	}
+	if _, err := Telegram.Request(tgbotapi.NewDeleteMessage(r.Msg.Chat.ID, r.Msg.MessageID)); err != nil {
+		logError("[close] Delete message failed", "error", err)
+		return Reply{Text: fmtErr(err.Error())}
+	}
+	return Reply{Keep: true}
+}
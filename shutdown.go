@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultShutdownTimeout - default time for in-flight updates to finish on shutdown
+const DefaultShutdownTimeout time.Duration = 30 * time.Second
+
+// time for cancelled updates and stopped pingers to finish
+const shutdownGrace time.Duration = 5 * time.Second
+
+// MsgRestarting - notice for updates interrupted by shutdown
+const MsgRestarting string = "Bot is restarting, try again in a minute."
+
+// placeholder message of update in progress
+type placeholder struct {
+	msg  tgbotapi.Message
+	text bool // "Waiting..." text message, otherwise only keyboard is replaced
+}
+
+// placeholders of updates in progress, key is chat and message id
+var (
+	Placeholders   = make(map[string]placeholder)
+	PlaceholdersMu sync.Mutex
+)
+
+// placeholder key
+func placeholderKey(m *tgbotapi.Message) string {
+	return fmt.Sprintf("%d:%d", m.Chat.ID, m.MessageID)
+}
+
+// register placeholder until update is processed
+func addPlaceholder(m *tgbotapi.Message, text bool) {
+	PlaceholdersMu.Lock()
+	defer PlaceholdersMu.Unlock()
+	Placeholders[placeholderKey(m)] = placeholder{msg: *m, text: text}
+}
+
+// remove placeholder after update is processed
+func donePlaceholder(m *tgbotapi.Message) {
+	PlaceholdersMu.Lock()
+	defer PlaceholdersMu.Unlock()
+	delete(Placeholders, placeholderKey(m))
+}
+
+// replace all remaining placeholders with restart notice
+func releasePlaceholders() {
+	PlaceholdersMu.Lock()
+	list := Placeholders
+	Placeholders = make(map[string]placeholder)
+	PlaceholdersMu.Unlock()
+	for _, p := range list {
+		m := p.msg
+		ctx := withUID(context.Background(), m.Chat.ID)
+		if p.text {
+			editTextRemoveKeyboard(&m, tr(ctx, MsgRestarting))
+		} else {
+			editKeyboard(&m, closeButton(ctx))
+		}
+	}
+	if len(list) > 0 {
+		logInfo("[shutdown] Placeholders released", "count", len(list))
+	}
+}
+
+// wait for function with timeout, returns false on timeout
+func waitTimeout(f func(), timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// stop receiving updates, finish or cancel in-flight updates, stop pingers and cron
+func shutdown(cancel context.CancelFunc) {
+	cfg := getConfig()
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	logInfo("[shutdown] Stopping", "timeout", timeout)
+	// stop receiving updates
+	if !cfg.UseWebhook {
+		Bot.StopReceivingUpdates()
+	}
+	for _, srv := range Servers {
+		ctx, cancelSrv := context.WithTimeout(context.Background(), shutdownGrace)
+		if err := srv.Shutdown(ctx); err != nil {
+			logError("[shutdown] HTTP server shutdown failed", "addr", srv.Addr, "error", err)
+		}
+		cancelSrv()
+	}
+	// finish in-flight updates, cancel them on timeout
+	if !waitTimeout(Updates.Wait, timeout) {
+		logWarning("[shutdown] Updates are not finished in time, cancelling")
+		cancel()
+		if !waitTimeout(Updates.Wait, shutdownGrace) {
+			logError("[shutdown] Cancelled updates are not finished")
+		}
+	}
+	releasePlaceholders()
+	// stop pingers, they send final statistics and remove stop button,
+	// user data is not changed as update workers may still run, ping mode is reset on start
+	PingersMu.Lock()
+	uids := make([]int64, 0, len(Pingers))
+	for uid := range Pingers {
+		uids = append(uids, uid)
+	}
+	PingersMu.Unlock()
+	for _, uid := range uids {
+		pingerStop(uid, nil)
+	}
+	if !waitTimeout(PingersWG.Wait, shutdownGrace) {
+		logError("[shutdown] Pingers are not finished")
+	}
+	// stop cron and wait for running jobs
+	if Cron != nil && !waitTimeout(func() { <-Cron.Stop().Done() }, shutdownGrace) {
+		logError("[shutdown] Cron jobs are not finished")
+	}
+	if Storage != nil {
+		if err := Storage.Close(); err != nil {
+			logError("[shutdown] Close store failed", "error", err)
+		}
+	}
+	if Audit != nil {
+		Audit.Close()
+	}
+	logInfo("[shutdown] Stopped")
+}
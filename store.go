@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNoUserData - user data is not saved in store
+var ErrNoUserData = errors.New("user data not found")
+
+// Store - persistent storage for user data and callback tokens
+type Store interface {
+	Load(uid int64) (*UserData, error) // returns ErrNoUserData if nothing saved
+	Save(uid int64, d *UserData) error
+	Delete(uid int64) error
+	LoadCallbacks() (map[string]*callbackPayload, error) // returns empty map if nothing saved
+	SaveCallbacks(tokens map[string]*callbackPayload) error
+	Close() error
+}
+
+// Storage - user data store object
+var Storage Store
+
+// open store backend configured in main config
+func openStore(backend string, dir string) (Store, error) {
+	if dir == "" {
+		dir = "data"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	switch backend {
+	case "", "gob":
+		return &gobStore{dir: dir}, nil
+	case "bolt":
+		return openBoltStore(filepath.Join(dir, "bot.db"))
+	}
+	return nil, fmt.Errorf("unknown store backend: %s", backend)
+}
+
+// encode user data to gob
+func encodeUserData(d *UserData) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(d)
+	return buf.Bytes(), err
+}
+
+// decode user data from gob
+func decodeUserData(data []byte) (*UserData, error) {
+	var d UserData
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d)
+	return &d, err
+}
+
+// encode callback tokens to gob
+func encodeCallbacks(tokens map[string]*callbackPayload) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(tokens)
+	return buf.Bytes(), err
+}
+
+// decode callback tokens from gob, no data is empty map
+func decodeCallbacks(data []byte) (map[string]*callbackPayload, error) {
+	tokens := make(map[string]*callbackPayload)
+	if data == nil {
+		return tokens, nil
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tokens)
+	return tokens, err
+}
+
+// write file to temp file and rename it to keep old data on failure
+func writeFileAtomic(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// gobStore - one gob file per user in data directory, callback tokens in callbacks.gob
+type gobStore struct {
+	dir string
+}
+
+// user data file name
+func (s *gobStore) filename(uid int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(uid, 10)+".gob")
+}
+
+// Load - read user data from file
+func (s *gobStore) Load(uid int64) (*UserData, error) {
+	data, err := os.ReadFile(s.filename(uid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoUserData
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeUserData(data)
+}
+
+// Save - write user data to file
+func (s *gobStore) Save(uid int64, d *UserData) error {
+	data, err := encodeUserData(d)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename(uid), data)
+}
+
+// Delete - remove user data file
+func (s *gobStore) Delete(uid int64) error {
+	err := os.Remove(s.filename(uid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// LoadCallbacks - read callback tokens from file
+func (s *gobStore) LoadCallbacks() (map[string]*callbackPayload, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, "callbacks.gob"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return decodeCallbacks(data)
+}
+
+// SaveCallbacks - write callback tokens to file
+func (s *gobStore) SaveCallbacks(tokens map[string]*callbackPayload) error {
+	data, err := encodeCallbacks(tokens)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, "callbacks.gob"), data)
+}
+
+// Close - nothing to close for files
+func (s *gobStore) Close() error {
+	return nil
+}
+
+// buckets for user data and callback tokens in bolt database
+var (
+	boltUsersBucket     = []byte("users")
+	boltCallbacksBucket = []byte("callbacks")
+)
+
+// key for all callback tokens in callbacks bucket
+var boltCallbacksKey = []byte("tokens")
+
+// boltStore - embedded key-value database
+type boltStore struct {
+	db *bolt.DB
+}
+
+// open bolt database file and create bucket
+func openBoltStore(filename string) (*boltStore, error) {
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltUsersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltCallbacksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// uid as database key
+func boltKey(uid int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(uid))
+	return key
+}
+
+// Load - read user data from database
+func (s *boltStore) Load(uid int64) (*UserData, error) {
+	var data []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		// value is valid only inside transaction
+		if v := tx.Bucket(boltUsersBucket).Get(boltKey(uid)); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if data == nil {
+		return nil, ErrNoUserData
+	}
+	return decodeUserData(data)
+}
+
+// Save - write user data to database
+func (s *boltStore) Save(uid int64, d *UserData) error {
+	data, err := encodeUserData(d)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).Put(boltKey(uid), data)
+	})
+}
+
+// Delete - remove user data from database
+func (s *boltStore) Delete(uid int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).Delete(boltKey(uid))
+	})
+}
+
+// LoadCallbacks - read callback tokens from database
+func (s *boltStore) LoadCallbacks() (map[string]*callbackPayload, error) {
+	var data []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltCallbacksBucket).Get(boltCallbacksKey); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return decodeCallbacks(data)
+}
+
+// SaveCallbacks - write callback tokens to database
+func (s *boltStore) SaveCallbacks(tokens map[string]*callbackPayload) error {
+	data, err := encodeCallbacks(tokens)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCallbacksBucket).Put(boltCallbacksKey, data)
+	})
+}
+
+// Close - close database
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
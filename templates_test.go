@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"inkotools-bot/inkotools"
+)
+
+// run tests with -update to rewrite golden files after template changes
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// compare text with golden file
+func assertGolden(t *testing.T, name string, text string) {
+	t.Helper()
+	filename := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v, run tests with -update to create it", err)
+	}
+	if text != string(want) {
+		t.Errorf("%s mismatch, got:\n%s\nwant:\n%s", filename, text, want)
+	}
+}
+
+// every template is rendered with data from recorded api responses in each language
+func TestTemplates(t *testing.T) {
+	setupTest(t)
+	ctx := userCtx(testViewer)
+	api := getAPI()
+	// template data is loaded with api client as in handlers
+	sw, err := api.GetSwitch(ctx, testSwitch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swDown, err := api.GetSwitch(ctx, "192.168.57.20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, err := api.GetFreePorts(ctx, testSwitch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := api.GetSwitchLogs(ctx, testSwitch, 0, logPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := getPortSummary(ctx, testSwitch, "5", "short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := getPortSummary(ctx, testSwitch, "5", "full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search, err := api.DBSearch(ctx, "lenina", 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calc, err := api.IPCalc(ctx, "10.15.1.14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		tpl  string
+		data interface{}
+	}{
+		{"sw", "sw.tmpl", sw},
+		{"sw_unavailable", "sw.tmpl", swDown},
+		{"sw_short", "sw.short.tmpl", sw},
+		{"sw_short_unavailable", "sw.short.tmpl", swDown},
+		{"ports", "port", ports},
+		{"port_short", "port.tmpl", short},
+		{"port_full", "port.tmpl", full},
+		{"log", "log.tmpl", events},
+		{"log_empty", "log.tmpl", []inkotools.LogEvent{}},
+		{"search", "search.tmpl", search},
+		{"ipcalc", "ipcalc.tmpl", calc},
+	}
+	covered := make(map[string]bool)
+	for _, lang := range languages() {
+		UsersMu.Lock()
+		Users[testViewer].Language = lang
+		UsersMu.Unlock()
+		for _, c := range cases {
+			covered[c.tpl] = true
+			t.Run(c.name+"."+lang, func(t *testing.T) {
+				assertGolden(t, c.name+"."+lang, fmtObj(ctx, c.data, c.tpl))
+			})
+		}
+	}
+	files, err := filepath.Glob(filepath.Join("templates", "*.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if !covered[filepath.Base(f)] {
+			t.Errorf("no golden test for template %s", f)
+		}
+	}
+}
+
+// timestamps are printed in user timezone
+func TestTemplateTimezone(t *testing.T) {
+	setupTest(t)
+	UsersMu.Lock()
+	Users[testViewer].Timezone = "Asia/Vladivostok"
+	UsersMu.Unlock()
+	events := []inkotools.LogEvent{{Time: time.Date(2026, 10, 15, 21, 4, 5, 0, time.UTC), Message: "Port 5 link down"}}
+	assertContains(t, fmtObj(userCtx(testViewer), events, "log.tmpl"), "[16.10.2026 07:04:05]")
+}
+
+// wrong user timezone falls back to main config timezone
+func TestTemplateTimezoneFallback(t *testing.T) {
+	setupTest(t)
+	CFGMu.Lock()
+	CFG.Timezone = "Asia/Vladivostok"
+	CFGMu.Unlock()
+	UsersMu.Lock()
+	Users[testViewer].Timezone = "Asia/Vladivostoc"
+	UsersMu.Unlock()
+	events := []inkotools.LogEvent{{Time: time.Date(2026, 10, 15, 21, 4, 5, 0, time.UTC), Message: "Port 5 link down"}}
+	assertContains(t, fmtObj(userCtx(testViewer), events, "log.tmpl"), "[16.10.2026 07:04:05]")
+}
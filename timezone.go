@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultTimezone - default timezone for timestamps in messages
+const DefaultTimezone string = "Europe/Moscow"
+
+// loaded locations, key is timezone name
+var (
+	locations   = make(map[string]*time.Location)
+	locationsMu sync.Mutex
+)
+
+// load location once
+func loadLocation(name string) (*time.Location, error) {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// get user timezone, user config overrides main config, uid 0 is main config timezone,
+// wrong timezone falls back to main config timezone, then to default one
+func userLocation(uid int64) *time.Location {
+	var names []string
+	UsersMu.RLock()
+	if u, ok := Users[uid]; ok && u.Timezone != "" {
+		names = append(names, u.Timezone)
+	}
+	UsersMu.RUnlock()
+	if name := getConfig().Timezone; name != "" {
+		names = append(names, name)
+	}
+	names = append(names, DefaultTimezone)
+	for _, name := range names {
+		loc, err := loadLocation(name)
+		if err == nil {
+			return loc
+		}
+		logWarning("[time] Load timezone failed", "timezone", name, "error", err)
+	}
+	return time.Local
+}
+
+// convert time to timezone of user from context
+func localTime(ctx context.Context, t time.Time) time.Time {
+	return t.In(userLocation(ctxUID(ctx)))
+}
+
+// template functions depending on user from context
+func userFuncs(ctx context.Context) template.FuncMap {
+	loc, lang := userLocation(ctxUID(ctx)), userLang(ctxUID(ctx))
+	return template.FuncMap{
+		"localTime": func(t time.Time) time.Time { return t.In(loc) },
+		"utc2msk":   func(t time.Time) time.Time { return t.In(loc) },
+		"tr":        func(s string) string { return translate(lang, s) },
+	}
+}